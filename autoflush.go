@@ -0,0 +1,39 @@
+package gcplog
+
+import (
+	"sync"
+	"time"
+)
+
+// autoFlusher flushes logger every interval until it's closed.
+type autoFlusher struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startAutoFlush(s *Stackdriver, interval time.Duration) *autoFlusher {
+	f := &autoFlusher{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				// errors are reported to error handler by GCP client
+				s.Flush()
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// Close stops flushing and waits for the goroutine to exit.
+func (f *autoFlusher) Close() error {
+	f.once.Do(func() { close(f.stop) })
+	<-f.done
+	return nil
+}
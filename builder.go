@@ -0,0 +1,99 @@
+package gcplog
+
+import (
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// EntryBuilder accumulates optional fields of single entry, so a rich
+// entry is logged without deriving loggers with With* methods:
+//
+//	l.Entry().Severity(logging.Error).Label("k", "v").Trace(t).Message("boom").Emit()
+//
+// Logger's labels, fields, request and trace still apply, entry's
+// own labels, request, trace and timestamp take precedence.
+type EntryBuilder struct {
+	s      *Stackdriver
+	e      logging.Entry
+	fields map[string]interface{}
+}
+
+// Entry returns builder of entry logged by s, by default at severity
+// set with SetDefaultSeverity.
+func (s *Stackdriver) Entry() *EntryBuilder {
+	return &EntryBuilder{
+		s:      s,
+		e:      logging.Entry{Severity: s.defaultSeverity()},
+		fields: map[string]interface{}{"message": ""},
+	}
+}
+
+// Severity sets severity of the entry.
+func (b *EntryBuilder) Severity(sev Severity) *EntryBuilder {
+	b.e.Severity = sev
+	return b
+}
+
+// Message sets message of the entry.
+func (b *EntryBuilder) Message(msg string) *EntryBuilder {
+	b.fields["message"] = msg
+	return b
+}
+
+// Field adds payload field to the entry.
+func (b *EntryBuilder) Field(key string, value interface{}) *EntryBuilder {
+	if key == "message" {
+		key = messageFieldKey
+	}
+	b.fields[key] = value
+	return b
+}
+
+// Label adds label to the entry.
+func (b *EntryBuilder) Label(key, value string) *EntryBuilder {
+	if b.e.Labels == nil {
+		b.e.Labels = Labels{}
+	}
+	b.e.Labels[key] = value
+	return b
+}
+
+// Trace sets Cloud Trace traceID of the entry, qualified as in WithTrace.
+func (b *EntryBuilder) Trace(traceID string) *EntryBuilder {
+	b.e.Trace = b.s.qualifyTrace(traceID)
+	return b
+}
+
+// Span sets span ID of the entry trace.
+func (b *EntryBuilder) Span(spanID string) *EntryBuilder {
+	b.e.SpanID = spanID
+	return b
+}
+
+// Timestamp sets time the entry occurred at.
+func (b *EntryBuilder) Timestamp(t time.Time) *EntryBuilder {
+	b.e.Timestamp = t
+	return b
+}
+
+// HTTPRequest sets HTTP request the entry is about. Requests without
+// Request are ignored.
+func (b *EntryBuilder) HTTPRequest(r *logging.HTTPRequest) *EntryBuilder {
+	if r != nil && r.Request != nil {
+		// GCP client panics on HTTPRequest without Request
+		b.e.HTTPRequest = r
+	}
+	return b
+}
+
+// Emit logs the entry, subject to logger's minimum level, sampling
+// and rate limit. Builder must not be used after Emit.
+func (b *EntryBuilder) Emit() {
+	msg, _ := b.fields["message"].(string)
+	if !b.s.allow(b.e.Severity, msg) {
+		return
+	}
+	b.e.Payload = b.fields
+	b.s.emit(b.e)
+}
@@ -0,0 +1,46 @@
+package gcplog
+
+import "sync"
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger *Stackdriver
+)
+
+// Default returns logger used by package-level functions. Unless set
+// with SetDefault, it's created with New(nil) on first use.
+func Default() *Stackdriver {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultLogger == nil {
+		defaultLogger = New(nil)
+	}
+	return defaultLogger
+}
+
+// SetDefault replaces logger used by package-level functions,
+// e.g. to log with configured logger or to record entries in tests.
+// Nil l makes Default create logger on next use again.
+func SetDefault(l *Stackdriver) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// Log does structural logging with provided severity using Default logger.
+func Log(sev Severity, msg string, args ...interface{}) { Default().Log(sev, msg, args...) }
+
+// Debug sends debug log message using Default logger.
+func Debug(msg string, args ...interface{}) { Default().Debug(msg, args...) }
+
+// Info sends info log message using Default logger.
+func Info(msg string, args ...interface{}) { Default().Info(msg, args...) }
+
+// Warn sends warn log message using Default logger.
+func Warn(msg string, args ...interface{}) { Default().Warn(msg, args...) }
+
+// Error sends error log message using Default logger.
+func Error(msg string, args ...interface{}) { Default().Error(msg, args...) }
+
+// Flush sends entries buffered by Default logger to GCP.
+func Flush() error { return Default().Flush() }
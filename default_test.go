@@ -0,0 +1,36 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"testing"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestDefault(t *testing.T) {
+	t.Cleanup(func() { gcplog.SetDefault(nil) })
+
+	var buf bytes.Buffer
+	gcplog.SetDefault(gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithMinLevel(logging.Debug)))
+	for _, tt := range []struct {
+		log  func(string, ...interface{})
+		want string
+	}{
+		{gcplog.Debug, "DEBUG"},
+		{gcplog.Info, "INFO"},
+		{gcplog.Warn, "WARNING"},
+		{gcplog.Error, "ERROR"},
+	} {
+		buf.Reset()
+		tt.log("hello", "k", "v")
+		got := structuredLine(t, buf.Bytes())
+		if got["severity"] != tt.want || got["message"] != "hello" || got["k"] != "v" {
+			t.Errorf("unexpected entry %v, want severity %s", got, tt.want)
+		}
+	}
+	if err := gcplog.Flush(); err != nil {
+		t.Errorf("flush: %v", err)
+	}
+}
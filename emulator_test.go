@@ -0,0 +1,659 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+
+	"github.com/velppa/gcplog"
+)
+
+// startTestServer starts TestServer stopped at the end of the test.
+func startTestServer(t *testing.T) (*gcplog.TestServer, []option.ClientOption) {
+	srv, opts := gcplog.NewTestServer()
+	t.Cleanup(srv.Close)
+	return srv, opts
+}
+
+func TestEmulator(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(
+		gcplog.Labels{"app": "test"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.With(gcplog.Labels{"k": "v"}).Warn("hello", "n", 1)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", entries)
+	}
+	e := entries[0]
+	if e.LogName != "projects/test-project/logs/test" || e.Severity != logtypepb.LogSeverity(logging.Warning) {
+		t.Errorf("unexpected entry %v", e)
+	}
+	if e.Labels["app"] != "test" || e.Labels["k"] != "v" {
+		t.Errorf("unexpected labels %v", e.Labels)
+	}
+	fields := e.GetJsonPayload().GetFields()
+	if fields["message"].GetStringValue() != "hello" || fields["n"].GetNumberValue() != 1 {
+		t.Errorf("unexpected payload %v", e.GetJsonPayload())
+	}
+}
+
+func TestSynchronous(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithSynchronous(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("audit")
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("entry must be sent before Info returns, got %v", entries)
+	}
+}
+
+func TestSynchronousCanceledContext(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var errs []error
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithSynchronous(),
+		gcplog.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.WithContext(ctx).Info("request done")
+
+	if entries := fake.Entries(); len(entries) != 1 || len(errs) != 0 {
+		t.Errorf("entry of canceled context must be sent, got %v, errors %v", entries, errs)
+	}
+}
+
+func TestWithoutStdout(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var buf bytes.Buffer
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(&buf),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithStdout(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hello")
+	l.Close()
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no output, got %q", buf.String())
+	}
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Errorf("expected entry sent to GCP, got %v", entries)
+	}
+}
+
+func TestConcurrentDeriveAndLog(t *testing.T) {
+	_, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "test"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	base := l.With(gcplog.Labels{"base": "1"})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				child := base.With(gcplog.Labels{"worker": strconv.Itoa(i)}).WithRequest(nil)
+				child.Info("hello", "j", j)
+				base.Printf("worker %d", i)
+				if c, ok := child.(*gcplog.Stackdriver); ok {
+					c.AddLabel("j", strconv.Itoa(j))
+					c.Warn("added")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestGCPLogger(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.GCPLogger().Log(logging.Entry{Payload: "raw", InsertID: "id-1"})
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 1 || entries[0].InsertId != "id-1" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestWithSeverityLog(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "app"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithSeverityLog(logging.Critical, "app-critical"),
+		gcplog.WithSeverityLog(logging.Error, "app-errors"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("info")
+	l.Error("error")
+	l.Log(logging.Alert, "alert")
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	got := map[string]string{}
+	for _, e := range fake.Entries() {
+		got[e.GetJsonPayload().GetFields()["message"].GetStringValue()] = e.LogName
+	}
+	want := map[string]string{
+		"info":  "projects/test-project/logs/app",
+		"error": "projects/test-project/logs/app-errors",
+		"alert": "projects/test-project/logs/app-critical",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("log names = %v, want %v", got, want)
+	}
+}
+
+func TestWithLogProjectID(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "app"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithLogProjectID("central-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithTrace("abc", "").Info("hello")
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if got, want := entries[0].LogName, "projects/central-project/logs/app"; got != want {
+		t.Errorf("log name = %q, want %q", got, want)
+	}
+	if got, want := entries[0].Trace, "projects/test-project/traces/abc"; got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+}
+
+func TestLabelsOverrideCommonLabels(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var buf bytes.Buffer
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "test", "env": "prod"},
+		gcplog.WithWriter(&buf),
+		gcplog.WithFormat(gcplog.FormatLogfmt),
+		gcplog.WithStdFlags(0),
+		gcplog.WithPrefix(""),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.With(gcplog.Labels{"env": "canary"}).Info("canary")
+	l.Info("prod")
+	l.Close()
+
+	got := map[string]string{}
+	for _, e := range fake.Entries() {
+		got[e.GetJsonPayload().GetFields()["message"].GetStringValue()] = e.Labels["env"]
+	}
+	if want := map[string]string{"canary": "canary", "prod": "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("env labels = %v, want %v", got, want)
+	}
+	want := "message=canary app=test env=canary\nmessage=prod app=test env=prod\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStdoutAndGCPLevels(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var buf bytes.Buffer
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(&buf),
+		gcplog.WithFormat(gcplog.FormatLogfmt),
+		gcplog.WithStdFlags(0),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithMinLevel(logging.Debug),
+		gcplog.WithStdoutLevel(logging.Debug),
+		gcplog.WithGCPLevel(logging.Warning),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("debug")
+	l.Warn("warn")
+	l.Close()
+
+	if want := "message=debug\nmessage=warn\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+	entries := fake.Entries()
+	if len(entries) != 1 || entries[0].GetJsonPayload().GetFields()["message"].GetStringValue() != "warn" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestLogWithLabels(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "test"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := l.With(gcplog.Labels{"k": "v"}).(*gcplog.Stackdriver)
+	child.LogWithLabels(logging.Info, gcplog.Labels{"user_id": "42", "k": "w"}, "signed in")
+	child.Info("next")
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	want := map[string]string{"app": "test", "k": "w", "user_id": "42"}
+	if !reflect.DeepEqual(entries[0].Labels, want) {
+		t.Errorf("labels = %v, want %v", entries[0].Labels, want)
+	}
+	if _, ok := entries[1].Labels["user_id"]; ok || entries[1].Labels["k"] != "v" {
+		t.Errorf("entry labels leaked to logger: %v", entries[1].Labels)
+	}
+}
+
+func TestEntryBuilder(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(gcplog.Labels{"app": "test"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.WithTrace("logger-trace", "").(*gcplog.Stackdriver).Entry().
+		Severity(logging.Error).
+		Label("k", "v").
+		Trace("abc").
+		Span("0000000000000001").
+		Timestamp(at).
+		Field("attempt", 2).
+		Message("boom").
+		Emit()
+	l.Entry().Severity(logging.Debug).Message("dropped").Emit()
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	e := entries[0]
+	fields := e.GetJsonPayload().GetFields()
+	if e.Severity != logtypepb.LogSeverity_ERROR || fields["message"].GetStringValue() != "boom" || fields["attempt"].GetNumberValue() != 2 {
+		t.Errorf("unexpected entry %v", e)
+	}
+	if e.Trace != "projects/test-project/traces/abc" || e.SpanId != "0000000000000001" || e.Labels["k"] != "v" || e.Labels["app"] != "test" {
+		t.Errorf("unexpected entry %v", e)
+	}
+	if !e.Timestamp.AsTime().Equal(at) {
+		t.Errorf("timestamp = %v, want %v", e.Timestamp.AsTime(), at)
+	}
+}
+
+func TestWithInsertID(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithInsertID("job-1").Info("done")
+	job := l.WithInsertID("job-2")
+	job.Info("started")
+	job.Info("done")
+	l.Close()
+
+	var ids []string
+	for _, e := range fake.Entries() {
+		ids = append(ids, e.InsertId)
+	}
+	if want := []string{"job-1", "job-2", "job-2-1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("insert IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	l.Info("now")
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.WithTimestamp(past).Info("past")
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if ts := entries[0].Timestamp.AsTime(); ts.Before(before.Truncate(time.Microsecond)) {
+		t.Errorf("timestamp %v must be set at call time after %v", ts, before)
+	}
+	if ts := entries[1].Timestamp.AsTime(); !ts.Equal(past) {
+		t.Errorf("timestamp = %v, want %v", ts, past)
+	}
+}
+
+func TestFlushContext(t *testing.T) {
+	fake, opts := startTestServer(t)
+	release := fake.Block()
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("stuck")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.FlushContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	release()
+	if err := l.FlushContext(context.Background()); err != nil {
+		t.Errorf("flush: %v", err)
+	}
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestWithAutoFlush(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithFlushSettings(gcplog.FlushSettings{DelayThreshold: time.Hour}),
+		gcplog.WithAutoFlush(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hello")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.Entries()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("entry was not flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	l.Close()
+	l.Close()
+}
+
+func TestDrain(t *testing.T) {
+	fake, opts := startTestServer(t)
+	fake.SetError(errors.New("unavailable"))
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithErrorHandler(func(error) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("lost")
+	if err := l.Drain(context.Background()); err == nil {
+		t.Error("expected drain to report failed entry")
+	}
+
+	fake.SetError(nil)
+	l.With(gcplog.Labels{"k": "v"}).Info("after drain")
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Errorf("entry must be sent before return, got %v", entries)
+	}
+}
+
+func TestNewWithContext(t *testing.T) {
+	_, opts := startTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gcplog.NewWithContext(ctx, nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	); !errors.Is(err, gcplog.ErrClient) {
+		t.Errorf("expected client error for cancelled context, got %v", err)
+	}
+
+	type key struct{}
+	ctx, cancel = context.WithTimeout(context.WithValue(context.Background(), key{}, "v"), time.Minute)
+	defer cancel()
+	l, err := gcplog.NewWithContext(ctx, nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cancel()
+	if l.Context().Value(key{}) != "v" || l.Context().Err() != nil {
+		t.Error("logger context must keep values but not cancellation")
+	}
+}
+
+func TestWithOperation(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := l.WithOperation("job-1", "importer").(*gcplog.Stackdriver)
+	op.Info("started")
+	op.Info("step")
+	op.EndOperation(logging.Info, "done")
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	for i, want := range []struct{ first, last bool }{{true, false}, {false, false}, {false, true}} {
+		got := entries[i].Operation
+		if got.GetId() != "job-1" || got.GetProducer() != "importer" || got.GetFirst() != want.first || got.GetLast() != want.last {
+			t.Errorf("entry %d: unexpected operation %v", i, got)
+		}
+	}
+}
+
+func TestLogProto(t *testing.T) {
+	fake, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.LogProto(logging.Info, &logpb.LogEntryOperation{Id: "job-1", Producer: "importer", First: true})
+	l.Close()
+
+	entries := fake.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	fields := entries[0].GetJsonPayload().GetFields()
+	if fields["id"].GetStringValue() != "job-1" || fields["producer"].GetStringValue() != "importer" || !fields["first"].GetBoolValue() {
+		t.Errorf("unexpected payload %v", fields)
+	}
+}
+
+func TestLogSyncResult(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var buf bytes.Buffer
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(&buf),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.LogSyncResult(context.Background(), logging.Notice, "charged", "amount", 10); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Entries()); n != 1 {
+		t.Errorf("entry must be sent before return, got %d entries", n)
+	}
+
+	fake.SetError(errors.New("unavailable"))
+	if err := l.LogSyncResult(context.Background(), logging.Notice, "refunded"); err == nil {
+		t.Error("expected error of rejected entry")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("refunded")) {
+		t.Errorf("entry must be printed, got %q", buf.String())
+	}
+}
+
+func TestLogSyncResultNotSent(t *testing.T) {
+	_, opts := startTestServer(t)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithGCPLevel(logging.Warning),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.LogSyncResult(context.Background(), logging.Info, "below"); !errors.Is(err, gcplog.ErrNotSent) {
+		t.Errorf("expected ErrNotSent below GCP level, got %v", err)
+	}
+
+	l = gcplog.New(nil, gcplog.WithWriter(ioutil.Discard), gcplog.WithGCP(false))
+	if err := l.LogSyncResult(context.Background(), logging.Error, "disabled"); !errors.Is(err, gcplog.ErrNotSent) {
+		t.Errorf("expected ErrNotSent with GCP disabled, got %v", err)
+	}
+}
+
+func TestExitFunc(t *testing.T) {
+	fake, opts := startTestServer(t)
+	var (
+		codes   []int
+		flushed int
+	)
+	l, err := gcplog.NewWithError(nil,
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithExitFunc(func(code int) {
+			codes = append(codes, code)
+			flushed = len(fake.Entries())
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Crit("crit")
+	if !reflect.DeepEqual(codes, []int{1}) || flushed != 1 {
+		t.Errorf("exit codes = %v with %d entries sent, want [1] after 1 entry", codes, flushed)
+	}
+	l.Fatalf("fatal %d", 2)
+	if len(codes) != 2 || flushed != 2 {
+		t.Errorf("exit codes = %v with %d entries sent after Fatalf", codes, flushed)
+	}
+}
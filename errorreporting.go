@@ -0,0 +1,41 @@
+package gcplog
+
+import (
+	"runtime/debug"
+
+	"cloud.google.com/go/logging"
+)
+
+// reportedErrorEventType makes Error Reporting pick up the entry, see
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// serviceContext identifies service in Error Reporting.
+type serviceContext struct {
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+}
+
+// reportError adds Error Reporting fields to payload: event type,
+// service context, report location and stack trace.
+func reportError(sc *serviceContext, payload map[string]interface{}) {
+	payload["@type"] = reportedErrorEventType
+	payload["serviceContext"] = sc
+	if loc := callerLocation(); loc != nil {
+		payload["context"] = map[string]interface{}{
+			"reportLocation": map[string]interface{}{
+				"filePath":     loc.File,
+				"lineNumber":   loc.Line,
+				"functionName": loc.Function,
+			},
+		}
+	}
+	if _, ok := payload[stackTraceKey]; !ok {
+		payload[stackTraceKey] = string(debug.Stack())
+	}
+}
+
+// reportable reports whether entry with severity sev goes to Error Reporting.
+func reportable(sev Severity) bool {
+	return sev >= logging.Error
+}
@@ -0,0 +1,42 @@
+package gcplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestErrorReporting(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Stackdriver{
+		Logger:         log.New(&buf, "", 0),
+		errorReporting: &serviceContext{Service: "svc", Version: "1.0"},
+	}
+	l.Info("info")
+	if strings.Contains(buf.String(), reportedErrorEventType) {
+		t.Errorf("info entry must not be reported: %s", buf.String())
+	}
+
+	buf.Reset()
+	l.Error("boom")
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["@type"] != reportedErrorEventType {
+		t.Errorf("unexpected @type %v", got["@type"])
+	}
+	sc, _ := got["serviceContext"].(map[string]interface{})
+	if sc["service"] != "svc" || sc["version"] != "1.0" {
+		t.Errorf("unexpected service context %v", got["serviceContext"])
+	}
+	loc := got["context"].(map[string]interface{})["reportLocation"].(map[string]interface{})
+	if !strings.HasSuffix(loc["filePath"].(string), "errorreporting_test.go") {
+		t.Errorf("unexpected report location %v", loc)
+	}
+	if st, _ := got[stackTraceKey].(string); !strings.Contains(st, "goroutine") {
+		t.Errorf("unexpected stack trace %q", st)
+	}
+}
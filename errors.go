@@ -0,0 +1,95 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Payload keys of error attached with WithError.
+const (
+	errorKey       = "error"
+	errorChainKey  = "error_chain"
+	errorCausesKey = "causes"
+	stackTraceKey  = "stack_trace"
+)
+
+// WithError returns logger attaching err to structured entries under
+// "error" key. Messages of wrapped errors, including ones joined with
+// errors.Join, are put under "error_chain" and as a tree of
+// {"error": ..., "causes": [...]} objects under "causes". Stack trace
+// of errors having StackTrace method (like the ones of
+// github.com/pkg/errors) is put under "stack_trace".
+func (s *Stackdriver) WithError(err error) ExtendedLogger {
+	c := s.clone()
+	c.err = err
+	return c
+}
+
+// errorFields returns payload fields describing err.
+func errorFields(err error) map[string]interface{} {
+	fields := map[string]interface{}{errorKey: err.Error()}
+	if chain := errorChain(err); len(chain) > 0 {
+		fields[errorChainKey] = chain
+		fields[errorCausesKey] = errorCauses(err)
+	}
+	if _, ok := reflect.TypeOf(err).MethodByName("StackTrace"); ok {
+		fields[stackTraceKey] = fmt.Sprintf("%+v", err)
+	}
+	return fields
+}
+
+// errorValue returns payload value of err passed as entry field: its
+// message, or message with causes when it wraps other errors.
+// Errors marshaling themselves to JSON are kept as is.
+func errorValue(err error) interface{} {
+	if _, ok := err.(json.Marshaler); ok {
+		return err
+	}
+	causes := errorCauses(err)
+	if len(causes) == 0 {
+		return err.Error()
+	}
+	return map[string]interface{}{errorKey: err.Error(), errorCausesKey: causes}
+}
+
+// unwrap returns errors directly wrapped by err, supporting both
+// Unwrap() error and Unwrap() []error methods.
+func unwrap(err error) []error {
+	switch u := err.(type) {
+	case interface{ Unwrap() []error }:
+		return u.Unwrap()
+	case interface{ Unwrap() error }:
+		if e := u.Unwrap(); e != nil {
+			return []error{e}
+		}
+	}
+	return nil
+}
+
+// errorChain returns messages of errors wrapped by err, depth-first.
+func errorChain(err error) []string {
+	var chain []string
+	for _, e := range unwrap(err) {
+		if e != nil {
+			chain = append(append(chain, e.Error()), errorChain(e)...)
+		}
+	}
+	return chain
+}
+
+// errorCauses returns errors wrapped by err as tree of objects.
+func errorCauses(err error) []interface{} {
+	var causes []interface{}
+	for _, e := range unwrap(err) {
+		if e == nil {
+			continue
+		}
+		c := map[string]interface{}{errorKey: e.Error()}
+		if cc := errorCauses(e); len(cc) > 0 {
+			c[errorCausesKey] = cc
+		}
+		causes = append(causes, c)
+	}
+	return causes
+}
@@ -0,0 +1,287 @@
+package gcplog
+
+import (
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Field is typed structured field of an entry payload.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns string field.
+func String(k, v string) Field { return Field{k, v} }
+
+// Int returns int field.
+func Int(k string, v int) Field { return Field{k, v} }
+
+// Int64 returns int64 field.
+func Int64(k string, v int64) Field { return Field{k, v} }
+
+// Float64 returns float64 field.
+func Float64(k string, v float64) Field { return Field{k, v} }
+
+// Bool returns bool field.
+func Bool(k string, v bool) Field { return Field{k, v} }
+
+// Duration returns time.Duration field.
+func Duration(k string, v time.Duration) Field { return Field{k, v} }
+
+// Time returns time.Time field.
+func Time(k string, v time.Time) Field { return Field{k, v} }
+
+// Err returns field with err under "error" key. Like other error
+// values err is emitted as its message, with causes when it wraps
+// other errors.
+func Err(err error) Field { return Field{"error", err} }
+
+// Any returns field with arbitrary value.
+func Any(k string, v interface{}) Field { return Field{k, v} }
+
+// fieldsPayload builds payload from msg and fields.
+func fieldsPayload(msg string, fields []Field) map[string]interface{} {
+	result := make(map[string]interface{}, len(fields)+1)
+	result["message"] = msg
+	for _, f := range fields {
+		result[f.Key] = f.Value
+	}
+	return result
+}
+
+// WithFields returns logger adding fields to payload of every entry.
+// Unlike With, values may be of any type and are not sent as labels.
+// Fields of the call take precedence over bound ones.
+// Inside Group fields are nested under the group.
+func (s *Stackdriver) WithFields(fields map[string]interface{}) ExtendedLogger {
+	f := make(map[string]interface{}, len(s.fields)+len(fields))
+	for k, v := range s.fields {
+		f[k] = v
+	}
+	g := groupMap(f, s.group)
+	for k, v := range fields {
+		g[k] = s.fieldValue(v)
+	}
+	c := s.clone()
+	c.fields = f
+	return c
+}
+
+// Lazy is field value computed only when entry is emitted, e.g.
+//
+//	l.Debug("state", "dump", gcplog.Lazy(func() interface{} { return big.Dump() }))
+//
+// doesn't call Dump when Debug entries are dropped. The function runs
+// at most once per emitted entry, after the entry passed level filters,
+// so values bound with WithFields are computed for every entry.
+type Lazy func() interface{}
+
+// fieldValue returns payload value of field value v: errors are
+// described with errorValue, durations and times are formatted as
+// strings with WithReadableValues. Lazy values are kept as is.
+func (s *Stackdriver) fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return errorValue(v)
+	case time.Duration:
+		if s.timeLayout != "" {
+			return v.String()
+		}
+	case time.Time:
+		if s.timeLayout != "" {
+			return v.Format(s.timeLayout)
+		}
+	}
+	return v
+}
+
+// resolveLazy replaces Lazy values of payload p with values they
+// compute. Nested maps holding Lazy values are copied, as they may be
+// shared with bound fields.
+func (s *Stackdriver) resolveLazy(p map[string]interface{}) {
+	for k, v := range p {
+		switch v := v.(type) {
+		case Lazy:
+			p[k] = s.fieldValue(v())
+		case map[string]interface{}:
+			if hasLazy(v) {
+				c := make(map[string]interface{}, len(v))
+				for k, v := range v {
+					c[k] = v
+				}
+				s.resolveLazy(c)
+				p[k] = c
+			}
+		}
+	}
+}
+
+// hasLazy reports whether payload p holds Lazy values.
+func hasLazy(p map[string]interface{}) bool {
+	for _, v := range p {
+		switch v := v.(type) {
+		case Lazy:
+			return true
+		case map[string]interface{}:
+			if hasLazy(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// payloadKey returns key payload field named key by default is
+// emitted under.
+func (s *Stackdriver) payloadKey(key string) string {
+	if k, ok := s.keys[key]; ok {
+		return k
+	}
+	return key
+}
+
+// renameKeys returns copy of payload with reserved keys renamed
+// with WithMessageKey and WithErrorKey. Like "message" value in args is
+// put under messageFieldKey, fields having the key a reserved one is
+// renamed to are kept under the key suffixed with "_field".
+func (s *Stackdriver) renameKeys(payload map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		if _, ok := s.keys[k]; !ok {
+			result[k] = v
+		}
+	}
+	for from, to := range s.keys {
+		v, ok := payload[from]
+		if !ok {
+			continue
+		}
+		if _, reserved := s.keys[to]; !reserved {
+			if field, ok := payload[to]; ok {
+				result[to+"_field"] = field
+			}
+		}
+		result[to] = v
+	}
+	return result
+}
+
+// Group returns logger nesting fields attached after it, with WithFields
+// or as args of the call, under payload key name. Repeated grouping
+// nests further, e.g. l.Group("db").Group("pool").Info("msg", "size", 1)
+// logs {"message":"msg","db":{"pool":{"size":1}}}.
+func (s *Stackdriver) Group(name string) ExtendedLogger {
+	if name == "" {
+		return s
+	}
+	c := s.clone()
+	c.group = append(s.group[:len(s.group):len(s.group)], name)
+	return c
+}
+
+// groupMap returns map at path of nested maps in m creating it. Maps
+// along the path are copied, so maps shared with other loggers or
+// passed by caller are not modified.
+func groupMap(m map[string]interface{}, path []string) map[string]interface{} {
+	for _, name := range path {
+		g, _ := m[name].(map[string]interface{})
+		c := make(map[string]interface{}, len(g)+1)
+		for k, v := range g {
+			c[k] = v
+		}
+		m[name] = c
+		m = c
+	}
+	return m
+}
+
+// groupPayload returns payload p with all fields but message
+// nested at group path.
+func groupPayload(p map[string]interface{}, path []string) map[string]interface{} {
+	n := len(p)
+	if _, ok := p["message"]; ok {
+		n--
+	}
+	if n == 0 {
+		// empty group is omitted
+		return p
+	}
+	result := map[string]interface{}{}
+	g := groupMap(result, path)
+	for k, v := range p {
+		if k == "message" {
+			result[k] = v
+			continue
+		}
+		g[k] = v
+	}
+	return result
+}
+
+// mergeFields adds bound fields to payload p unless already set there,
+// nested maps of groups are merged.
+func mergeFields(p, fields map[string]interface{}) {
+	for k, v := range fields {
+		cur, ok := p[k]
+		if !ok {
+			p[k] = v
+			continue
+		}
+		pm, ok := cur.(map[string]interface{})
+		fm, ok2 := v.(map[string]interface{})
+		if !ok || !ok2 {
+			continue
+		}
+		m := make(map[string]interface{}, len(pm)+len(fm))
+		for k, v := range pm {
+			m[k] = v
+		}
+		mergeFields(m, fm)
+		p[k] = m
+	}
+}
+
+// LogFields is like Log but takes typed fields.
+func (s *Stackdriver) LogFields(sev Severity, msg string, fields ...Field) {
+	if s.allow(sev, msg) {
+		s.logPayload(sev, fieldsPayload(msg, fields))
+	}
+}
+
+// DebugFields sends debug log message with fields.
+func (s *Stackdriver) DebugFields(msg string, fields ...Field) {
+	s.LogFields(logging.Debug, msg, fields...)
+}
+
+// InfoFields sends info log message with fields.
+func (s *Stackdriver) InfoFields(msg string, fields ...Field) {
+	s.LogFields(logging.Info, msg, fields...)
+}
+
+// WarnFields sends warn log message with fields.
+func (s *Stackdriver) WarnFields(msg string, fields ...Field) {
+	s.LogFields(logging.Warning, msg, fields...)
+}
+
+// ErrorFields sends error log message with fields.
+func (s *Stackdriver) ErrorFields(msg string, fields ...Field) {
+	s.LogFields(logging.Error, msg, fields...)
+}
+
+// FatalFields is like Fatal but sends message with fields, then flushes
+// entries and calls os.Exit(1), see WithExitFunc.
+func (s *Stackdriver) FatalFields(msg string, fields ...Field) {
+	s.logPayload(logging.Critical, fieldsPayload(msg, fields))
+	s.Flush()
+	s.exitProcess(1)
+}
+
+// PanicFields is like Panic but sends message with fields, then flushes
+// entries and panics with msg.
+func (s *Stackdriver) PanicFields(msg string, fields ...Field) {
+	s.logPayload(logging.Critical, fieldsPayload(msg, fields))
+	s.Flush()
+	panic(msg)
+}
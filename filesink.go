@@ -0,0 +1,110 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// fileSink writes entries to a file as Cloud Logging JSON lines,
+// rotating it when it grows over maxSize bytes. Rotated files are
+// renamed to path.1, path.2 and so on, path.1 being the most recent.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// newFileSink returns sink writing to path, the file is opened
+// on first write.
+func newFileSink(path string, maxSizeMB, maxBackups int) *fileSink {
+	return &fileSink{
+		path:       path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxBackups: maxBackups,
+	}
+}
+
+// WriteEntry writes entry e as single JSON line.
+func (fs *fileSink) WriteEntry(e logging.Entry) error {
+	b, err := json.Marshal(entryJSON(e, e.Labels))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f != nil && fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(b)) > fs.maxSize {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+	if fs.f == nil {
+		if err := fs.open(); err != nil {
+			return err
+		}
+	}
+	n, err := fs.f.Write(b)
+	fs.size += int64(n)
+	return err
+}
+
+// open opens or creates the file for appending.
+func (fs *fileSink) open() error {
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f = f
+	fs.size = info.Size()
+	return nil
+}
+
+// rotate closes the file and shifts it with older backups,
+// removing the ones over maxBackups.
+func (fs *fileSink) rotate() error {
+	err := fs.f.Close()
+	fs.f = nil
+	if err != nil {
+		return err
+	}
+	if fs.maxBackups <= 0 {
+		return os.Remove(fs.path)
+	}
+	os.Remove(fs.backup(fs.maxBackups))
+	for i := fs.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(fs.backup(i), fs.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(fs.path, fs.backup(1))
+}
+
+func (fs *fileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", fs.path, i)
+}
+
+// Close closes the file, next write opens it again.
+func (fs *fileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Close()
+	fs.f = nil
+	return err
+}
@@ -0,0 +1,47 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	fs := newFileSink(path, 0, 2)
+	fs.maxSize = 100
+	defer fs.Close()
+
+	for _, msg := range []string{"first", "second", "third", "fourth"} {
+		e := logging.Entry{
+			Severity: logging.Warning,
+			Payload:  map[string]interface{}{"message": msg, "pad": strings.Repeat("x", 30)},
+			Labels:   Labels{"app": "test"},
+		}
+		if err := fs.WriteEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]string{path: "fourth", path + ".1": "third", path + ".2": "second"} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("unmarshal %q failed: %s", b, err)
+		}
+		if got[fieldMessage] != want || got[fieldSeverity] != "WARNING" || got[fieldLabels] == nil {
+			t.Errorf("unexpected entry in %s: %s", file, b)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected backups over limit to be removed, got %v", err)
+	}
+}
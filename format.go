@@ -0,0 +1,122 @@
+package gcplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Format is the format structured entries are printed in to stdout.
+type Format int
+
+const (
+	// FormatJSON prints payload as JSON object.
+	FormatJSON Format = iota
+	// FormatLogfmt prints payload as key=value pairs.
+	FormatLogfmt
+	// FormatPlain prints message followed by key=value pairs.
+	FormatPlain
+)
+
+// formatText renders payload with message under msgKey in format f.
+func formatText(f Format, payload map[string]interface{}, msgKey string) (string, error) {
+	switch f {
+	case FormatLogfmt:
+		return logfmt(payload, msgKey, nil), nil
+	case FormatPlain:
+		msg := fmt.Sprint(payload[msgKey])
+		if kv := logfmt(payload, msgKey, map[string]bool{msgKey: true}); kv != "" {
+			return msg + " " + kv, nil
+		}
+		return msg, nil
+	default:
+		return marshalJSON(payload)
+	}
+}
+
+// maxPooledBuffer is capacity of the largest buffer kept in bufferPool.
+const maxPooledBuffer = 64 << 10
+
+// bufferPool holds buffers entries are marshaled to JSON in.
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// marshalJSON is like json.Marshal but encodes v to pooled buffer,
+// so only the returned string is allocated.
+func marshalJSON(v interface{}) (string, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		// huge buffers aren't kept to not pin their memory
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return "", err
+	}
+	// Encode terminates value with newline
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})), nil
+}
+
+// logfmt renders payload as key=value pairs with message under msgKey
+// first and other keys sorted. Keys from skip are omitted.
+func logfmt(payload map[string]interface{}, msgKey string, skip map[string]bool) string {
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		if !skip[k] && k != msgKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := payload[msgKey]; ok && !skip[msgKey] {
+		keys = append([]string{msgKey}, keys...)
+	}
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(payload[k]))
+	}
+	return b.String()
+}
+
+func logfmtValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// appendLabels returns text followed by labels as key=value pairs.
+func appendLabels(text string, labels Labels) string {
+	if len(labels) == 0 {
+		return text
+	}
+	m := make(map[string]interface{}, len(labels))
+	for k, v := range labels {
+		m[k] = v
+	}
+	kv := logfmt(m, "", nil)
+	if text == "" {
+		return kv
+	}
+	return text + " " + kv
+}
+
+// withLabels returns copy of payload with labels under "labels" key.
+func withLabels(payload map[string]interface{}, labels Labels) map[string]interface{} {
+	result := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		result[k] = v
+	}
+	result["labels"] = labels
+	return result
+}
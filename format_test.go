@@ -0,0 +1,24 @@
+package gcplog
+
+import "testing"
+
+func TestFormatText(t *testing.T) {
+	payload := map[string]interface{}{"message": "hello world", "b": 2, "a": "x y"}
+	tests := []struct {
+		f    Format
+		want string
+	}{
+		{FormatJSON, `{"a":"x y","b":2,"message":"hello world"}`},
+		{FormatLogfmt, `message="hello world" a="x y" b=2`},
+		{FormatPlain, `hello world a="x y" b=2`},
+	}
+	for _, tt := range tests {
+		got, err := formatText(tt.f, payload, "message")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("format %d = %s, want %s", tt.f, got, tt.want)
+		}
+	}
+}
@@ -3,14 +3,19 @@ package gcplog
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/logging"
-	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
 // Logger is a standard logging interface.
@@ -33,62 +38,274 @@ type ExtendedLogger interface {
 	Logger
 
 	WithRequest(*logging.HTTPRequest) ExtendedLogger
+	WithContext(ctx context.Context) ExtendedLogger
+	WithTrace(traceID, spanID string) ExtendedLogger
+	WithError(err error) ExtendedLogger
+	WithFields(fields map[string]interface{}) ExtendedLogger
+	Group(name string) ExtendedLogger
+	Named(name string) ExtendedLogger
 	With(labels map[string]string) ExtendedLogger
 
 	Log(s Severity, msg string, args ...interface{})
+	Debug(msg string, args ...interface{})
 	Info(msg string, args ...interface{})
 	Warn(msg string, args ...interface{})
 	Error(msg string, args ...interface{})
 	Crit(msg string, args ...interface{})
+
+	Flush() error
+	Close() error
 }
 
 // Stackdriver logs to GCP Stackdriver and also prints them to stdout.
 type Stackdriver struct {
+	client    *logging.Client
 	gcpLogger *logging.Logger
+	// routes are GCP loggers of severities set with WithSeverityLog.
+	routes []logRoute
 	*log.Logger
 
+	// out, prefix and name are used to create stdout logger for Named.
+	out    io.Writer
+	prefix string
+	name   string
+
+	// commonLabels and labels are never modified after creation,
+	// so they may be shared between derived loggers and entries
+	// buffered by GCP client.
 	commonLabels map[string]string
 	labels       map[string]string
+	// labelWarnings holds label changes already warned about, shared
+	// by derived loggers.
+	labelWarnings *sync.Map
 
 	req *logging.HTTPRequest
+	ctx context.Context
+
+	projectID    string
+	trace        string
+	spanID       string
+	traceSampled bool
+	insertID     string
+	timestamp    time.Time
+	op           *operation
+	opLast       bool
+	// insertSeq counts entries logged with insertID, shared by
+	// derived loggers and updated atomically.
+	insertSeq *uint64
+
+	sourceLocation bool
+	noStdout       bool
+	// stdoutLevel and gcpLevel are minimum severities of entries
+	// printed and sent to GCP on top of minLevel.
+	stdoutLevel Severity
+	gcpLevel    Severity
+
+	// minLevel is accessed atomically.
+	minLevel int32
+	// printSeverity is severity of Print entries plus one, so that
+	// zero value means logging.Info. It's accessed atomically.
+	printSeverity int32
+
+	// jsonLogger writes entries as Cloud Logging JSON lines
+	// in structured stdout mode.
+	jsonLogger *log.Logger
+	format     Format
+	redactor   *redactor
+	replace    ReplaceFunc
+	validate   *validator
+	// keys maps reserved payload keys to ones set with
+	// WithMessageKey and WithErrorKey.
+	keys map[string]string
+	// ctxFields are context values added as fields.
+	ctxFields []contextField
+
+	// fields are bound payload fields, never modified after creation.
+	fields map[string]interface{}
+	// group is path of nested maps fields are put in, see Group.
+	group []string
+	err   error
+
+	errorReporting *serviceContext
+	synchronous    bool
+	// draining is shared by derived loggers and set atomically by Drain.
+	draining       *int32
+	sampler        *sampler
+	limiter        *rateLimiter
+	sinks          []Sink
+	closers        []io.Closer
+	recent         *ringBuffer
+	onError        func(error)
+	counters       *counters
+	maxPayloadSize int
+	exit           func(int)
+	// timeLayout, when set, makes durations and times logged as strings.
+	timeLayout string
+}
+
+// clone returns a copy of s to be modified by derived logger.
+// All configuration is copied, so derived loggers behave as s.
+func (s *Stackdriver) clone() *Stackdriver {
+	c := *s
+	c.minLevel = atomic.LoadInt32(&s.minLevel)
+	c.printSeverity = atomic.LoadInt32(&s.printSeverity)
+	return &c
 }
 
+// copyLabels returns copy of labels with capacity for n more entries.
+func copyLabels(labels Labels, n int) Labels {
+	l := make(Labels, len(labels)+n)
+	for k, v := range labels {
+		l[k] = v
+	}
+	return l
+}
+
+// WithRequest returns logger attaching req to entries.
+// Trace and its sampled flag are extracted from traceparent or
+// X-Cloud-Trace-Context header of req.Request, traceparent is preferred
+// when both are present.
 func (s *Stackdriver) WithRequest(req *logging.HTTPRequest) ExtendedLogger {
-	return &Stackdriver{
-		gcpLogger:    s.gcpLogger,
-		Logger:       s.Logger,
-		commonLabels: s.commonLabels,
-		labels:       s.labels,
-		req:          req,
+	c := s.clone()
+	c.req = req
+	if req != nil && req.Request != nil {
+		if traceID, spanID, sampled, ok := requestTrace(req.Request.Header); ok {
+			c.trace = c.qualifyTrace(traceID)
+			c.spanID = spanID
+			c.traceSampled = sampled
+		}
 	}
+	return c
 }
 
+// With returns logger adding labels to entries. Labels override common
+// labels passed to New with the same keys, both in GCP, which keeps
+// entry labels over common ones, and in stdout output, so a common
+// label may be changed for a subset of entries:
+//
+//	canary := l.With(gcplog.Labels{"env": "canary"})
 func (s *Stackdriver) With(labels map[string]string) ExtendedLogger {
-	l := s.labels
-	if l == nil {
-		l = Labels{}
+	l := copyLabels(s.labels, len(labels))
+	for k, v := range labels {
+		l[k] = v
 	}
+	s.sanitizeLabels(l)
+	c := s.clone()
+	c.labels = l
+	return c
+}
+
+// AddLabel sets label k to v in place without deriving new logger.
+// Unlike With, it's not safe for concurrent use and must only be called
+// by the owner of the logger. Already derived loggers are not affected.
+func (s *Stackdriver) AddLabel(k, v string) {
+	l := copyLabels(s.labels, 1)
+	l[k] = v
+	s.sanitizeLabels(l)
+	s.labels = l
+}
+
+// SetLabels merges labels in place without deriving new logger.
+// See AddLabel for concurrency notes.
+func (s *Stackdriver) SetLabels(labels Labels) {
+	l := copyLabels(s.labels, len(labels))
 	for k, v := range labels {
 		l[k] = v
 	}
-	return &Stackdriver{
-		gcpLogger:    s.gcpLogger,
-		Logger:       s.Logger,
-		commonLabels: s.commonLabels,
-		labels:       l,
-		req:          s.req,
+	s.sanitizeLabels(l)
+	s.labels = l
+}
+
+// WithContext returns logger bound to request-scoped ctx.
+// Context is inherited by derived loggers. Values of ctx under keys
+// set with WithContextField are added as fields.
+func (s *Stackdriver) WithContext(ctx context.Context) ExtendedLogger {
+	var c *Stackdriver
+	if fields := s.contextFields(ctx); len(fields) > 0 {
+		c = s.WithFields(fields).(*Stackdriver)
+	} else {
+		c = s.clone()
+	}
+	c.ctx = ctx
+	return c
+}
+
+// contextField is context value added as field set with WithContextField.
+type contextField struct {
+	name string
+	key  interface{}
+}
+
+// contextFields returns fields of values of ctx set with WithContextField.
+func (s *Stackdriver) contextFields(ctx context.Context) map[string]interface{} {
+	if len(s.ctxFields) == 0 || ctx == nil {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(s.ctxFields))
+	for _, f := range s.ctxFields {
+		if v := ctx.Value(f.key); v != nil {
+			fields[f.name] = v
+		}
+	}
+	return fields
+}
+
+// Context returns context logger is bound to, context.Background() by default.
+func (s *Stackdriver) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
 	}
+	return s.ctx
 }
 
+// valuesContext is context with values of parent but never done.
+type valuesContext struct{ parent context.Context }
+
+func (valuesContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}               { return nil }
+func (valuesContext) Err() error                          { return nil }
+func (c valuesContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
 type Severity = logging.Severity
 
+// ParseSeverity returns Severity by its case-insensitive name,
+// e.g. "debug", "info", "warning" or "warn", "error", "critical" or "crit".
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "default":
+		return logging.Default, nil
+	case "debug":
+		return logging.Debug, nil
+	case "info":
+		return logging.Info, nil
+	case "notice":
+		return logging.Notice, nil
+	case "warning", "warn":
+		return logging.Warning, nil
+	case "error":
+		return logging.Error, nil
+	case "critical", "crit":
+		return logging.Critical, nil
+	case "alert":
+		return logging.Alert, nil
+	case "emergency":
+		return logging.Emergency, nil
+	}
+	return logging.Default, fmt.Errorf("unknown severity %q", name)
+}
+
 type Labels = map[string]string
 
-// getGCPProjectID returns GCP project id.
+// getGCPProjectID returns GCP project id from EnvConfig file
+// falling back to metadata server when env variable is not set.
 func getGCPProjectID() (string, error) {
 	filename := os.Getenv(EnvConfig)
 	if filename == "" {
-		return "", fmt.Errorf("env var %s is not set", EnvConfig)
+		id, err := getMetadataProjectID()
+		if err != nil {
+			return "", fmt.Errorf("%w: env var %s is not set, %s", ErrNoProjectID, EnvConfig, err)
+		}
+		return id, nil
 	}
 	bytes, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -103,61 +320,271 @@ func getGCPProjectID() (string, error) {
 	return payload.ProjectID, nil
 }
 
-const appName = "nyancat"
+// defaultLogName is used as GCP log name when "app" label is not provided.
+const defaultLogName = "gcplog"
+
+// logName returns GCP log name for common labels cl.
+func logName(cl map[string]string) string {
+	if app := cl["app"]; app != "" {
+		return app
+	}
+	return defaultLogName
+}
 
 // EnvConfig is the name of env variable pointing to
 // json file with GCP credentials.
 const EnvConfig = "GOOGLE_APPLICATION_CREDENTIALS"
 
-func buildGCPLogger(cl map[string]string) *logging.Logger {
-	projectID, err := getGCPProjectID()
-	if err != nil {
-		log.Printf("Failed to get GCP credentials: %s", err)
-		return nil
+var (
+	// ErrNoProjectID is returned when GCP project ID is not configured:
+	// WithProjectID option is not used, EnvConfig env variable is not set
+	// and metadata server is not available.
+	ErrNoProjectID = errors.New("GCP project ID not configured")
+
+	// ErrClient is returned when GCP logging client can't be created.
+	ErrClient = errors.New("create GCP logging client failed")
+
+	// ErrNotSent is returned by LogSyncResult when entry isn't sent to
+	// GCP as GCP logging is disabled or entry is below WithGCPLevel.
+	ErrNotSent = errors.New("entry not sent to GCP")
+)
+
+func buildGCPLogger(ctx context.Context, cfg *config, cl map[string]string) (*logging.Client, *logging.Logger, error) {
+	projectID := cfg.projectID
+	if projectID == "" {
+		var err error
+		projectID, err = getGCPProjectID()
+		switch {
+		case err == nil:
+		case cfg.logProjectID != "":
+			projectID = cfg.logProjectID
+		default:
+			return nil, nil, fmt.Errorf("get GCP credentials failed: %w", err)
+		}
+		cfg.projectID = projectID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrClient, err)
+	}
+	parent := projectID
+	if cfg.logProjectID != "" {
+		parent = cfg.logProjectID
 	}
-	client, err := logging.NewClient(context.Background(), projectID)
+	client, err := logging.NewClient(ctx, parent, cfg.clientOpts...)
 	if err != nil {
-		log.Printf("Failed to create GCP logging client: %s", err)
-		return nil
+		return nil, nil, fmt.Errorf("%w: %s", ErrClient, err)
+	}
+	resource := ProjectResource(projectID)
+	if cfg.resource != nil {
+		resource = completeResource(cfg.resource, projectID)
 	}
-	return client.Logger(
-		appName,
-		logging.CommonResource(&mrpb.MonitoredResource{
-			Type:   "project",
-			Labels: map[string]string{"project_id": projectID},
-		}),
+	loggerOpts := append([]logging.LoggerOption{
+		logging.CommonResource(resource),
 		logging.CommonLabels(cl),
-	)
+	}, cfg.flush.loggerOptions()...)
+	for i, r := range cfg.routes {
+		cfg.routes[i].logger = client.Logger(r.logName, loggerOpts...)
+	}
+	return client, client.Logger(cfg.logName, loggerOpts...), nil
 }
 
-func New(cl map[string]string) *Stackdriver {
-	sd := &Stackdriver{
-		gcpLogger:    buildGCPLogger(cl),
-		commonLabels: cl,
-		Logger:       log.New(os.Stderr, "", log.LstdFlags),
+// New creates Stackdriver logger with common labels cl configured by opts.
+// If GCP logging can't be initialized warning explaining the reason
+// is logged and returned logger writes to stderr only, see GCPEnabled.
+func New(cl map[string]string, opts ...Option) *Stackdriver {
+	sd, err := NewWithError(cl, opts...)
+	if err != nil {
+		sd.logKV(logging.Warning, "GCP logging disabled, entries are only printed",
+			"reason", err.Error(), "hint", gcpErrorHint(err))
+	}
+	return sd
+}
+
+// gcpErrorHint returns advice on fixing GCP initialization error err.
+func gcpErrorHint(err error) string {
+	switch {
+	case errors.Is(err, ErrNoProjectID):
+		return fmt.Sprintf("set %s env variable or use WithProjectID option", EnvConfig)
+	case errors.Is(err, ErrClient):
+		return "check credentials and connectivity to GCP"
 	}
+	return "check GCP configuration"
+}
+
+// GCPEnabled reports whether entries are sent to GCP. It's false
+// when GCP logging failed to initialize, or was disabled with WithGCP
+// or WithStructuredStdout.
+func (s *Stackdriver) GCPEnabled() bool {
+	return s.gcpLogger != nil
+}
+
+// NewWithError is like New but returns GCP initialization error
+// instead of printing it. Use errors.Is with ErrNoProjectID or ErrClient
+// to find out the reason. When err is not nil returned logger
+// writes to stderr only.
+func NewWithError(cl map[string]string, opts ...Option) (*Stackdriver, error) {
+	return NewWithContext(context.Background(), cl, opts...)
+}
+
+// NewWithContext is like NewWithError but creates GCP client with ctx,
+// so startup deadline aborts client creation. Values of ctx, but not
+// its cancellation, are kept as the logger's context.
+func NewWithContext(ctx context.Context, cl map[string]string, opts ...Option) (*Stackdriver, error) {
+	var labelChanges []string
 	if cl != nil {
+		// common labels must not change with caller's map
+		cl = copyLabels(cl, 0)
+		labelChanges = sanitizeLabels(cl)
+	}
+	cfg := newConfig(cl, opts)
+	levelWarning := cfg.applyLevelEnv()
+	if cfg.runtimeLabels {
+		cl = addRuntimeLabels(cl)
+	}
+	var (
+		client    *logging.Client
+		gcpLogger *logging.Logger
+		err       error
+	)
+	if !cfg.structuredStdout && cfg.gcp {
+		client, gcpLogger, err = buildGCPLogger(ctx, cfg, cl)
+	}
+	sd := &Stackdriver{
+		client:         client,
+		gcpLogger:      gcpLogger,
+		Logger:         log.New(cfg.writer, "", cfg.stdFlags),
+		commonLabels:   cl,
+		labelWarnings:  &sync.Map{},
+		projectID:      cfg.projectID,
+		sourceLocation: cfg.sourceLocation,
+		noStdout:       !cfg.stdout,
+		stdoutLevel:    cfg.stdoutLevel,
+		gcpLevel:       cfg.gcpLevel,
+		minLevel:       int32(cfg.minLevel),
+		format:         cfg.format,
+		redactor:       cfg.redactor,
+		replace:        cfg.replace,
+		keys:           cfg.keys,
+		ctxFields:      cfg.ctxFields,
+		errorReporting: cfg.errorReporting,
+		synchronous:    cfg.synchronous,
+		sampler:        cfg.sampler,
+		limiter:        cfg.limiter,
+		sinks:          cfg.sinks,
+		closers:        cfg.closers,
+		recent:         cfg.recent,
+		counters:       &counters{},
+		draining:       new(int32),
+		maxPayloadSize: cfg.maxPayloadSize,
+		exit:           cfg.exit,
+		timeLayout:     cfg.timeLayout,
+	}
+	if cfg.validate != nil {
+		sd.validate = &validator{f: cfg.validate, drop: cfg.dropInvalid}
+	}
+	if ctx != context.Background() {
+		sd.ctx = valuesContext{ctx}
+		for k, v := range sd.contextFields(ctx) {
+			if sd.fields == nil {
+				sd.fields = map[string]interface{}{}
+			}
+			sd.fields[k] = sd.fieldValue(v)
+		}
+	}
+	if cfg.structuredStdout {
+		sd.jsonLogger = log.New(cfg.writer, "", 0)
+	}
+	handler := sd.errorHandler(cfg)
+	sd.onError = func(err error) {
+		atomic.AddUint64(&sd.counters.errors, 1)
+		handler(err)
+	}
+	if client != nil {
+		client.OnError = sd.onError
+		sd.routes = cfg.routes
+		if cfg.autoFlush > 0 {
+			sd.closers = append(sd.closers, startAutoFlush(sd, cfg.autoFlush))
+		}
+	}
+	sd.out = cfg.writer
+	if cfg.prefixSet {
+		sd.prefix = cfg.prefix
+		sd.Logger.SetPrefix(sd.prefix)
+	} else if cl != nil {
 		app := cl["app"]
 		module := cl["module"]
-		sd.Logger.SetPrefix(strings.TrimSpace(fmt.Sprintf("%s %s", app, module)) + " ")
+		sd.prefix = strings.TrimSpace(fmt.Sprintf("%s %s", app, module)) + " "
+		sd.Logger.SetPrefix(sd.prefix)
+	}
+	for _, c := range labelChanges {
+		sd.warnLabel(c)
+	}
+	if levelWarning != "" {
+		sd.warner().logKV(logging.Warning, levelWarning)
+	}
+	return sd, err
+}
+
+// addRuntimeLabels returns common labels cl with runtime labels added
+// unless already set. cl is modified in place.
+func addRuntimeLabels(cl Labels) Labels {
+	rl := runtimeLabels()
+	if len(rl) == 0 {
+		return cl
+	}
+	if cl == nil {
+		cl = make(Labels, len(rl))
+	}
+	for k, v := range rl {
+		if _, ok := cl[k]; !ok {
+			cl[k] = v
+		}
+	}
+	return cl
+}
+
+// errorHandler returns GCP client error handler from cfg,
+// printing errors to stdout logger by default.
+func (s *Stackdriver) errorHandler(cfg *config) func(error) {
+	if cfg.onError != nil {
+		return cfg.onError
+	}
+	return func(err error) {
+		if errors.Is(err, ErrSink) {
+			s.Logger.Print(err)
+			return
+		}
+		s.Logger.Printf("GCP logging failed: %s", err)
 	}
-	return sd
 }
 
+// extraKey is the payload key of dangling value in odd-length args.
+const extraKey = "EXTRA"
+
+// messageFieldKey is the payload key of "message" value in args,
+// since "message" key holds msg.
+const messageFieldKey = "message_field"
+
+// formatPayload builds payload from msg and alternating key/value args.
+// Non-string keys are formatted with fmt.Sprint, the value without
+// key is put under extraKey. msg takes precedence over "message" key
+// of args which value is put under messageFieldKey.
 func formatPayload(msg string, args ...interface{}) map[string]interface{} {
 	result := map[string]interface{}{"message": msg}
 
-	isKey := true
-	var k string
-	for i := range args {
-		a := args[i]
-		if isKey {
-			k = a.(string)
-			isKey = false
-		} else {
-			result[k] = a
-			isKey = true
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			result[extraKey] = args[i]
+			break
+		}
+		k, ok := args[i].(string)
+		if !ok {
+			k = fmt.Sprint(args[i])
+		}
+		if k == "message" {
+			k = messageFieldKey
 		}
+		result[k] = args[i+1]
 	}
 	return result
 }
@@ -166,59 +593,333 @@ func (s *Stackdriver) Print(args ...interface{})   { s.Printf(fmt.Sprint(args...
 func (s *Stackdriver) Println(args ...interface{}) { s.Printf(fmt.Sprintln(args...)) }
 
 func (s *Stackdriver) Printf(msg string, args ...interface{}) {
-	s.log(logging.Info, msg, args...)
+	s.log(s.defaultSeverity(), msg, args...)
+}
+
+// SetDefaultSeverity sets severity of entries logged with Print, Printf
+// and Println, logging.Info by default. Already derived loggers are
+// not affected.
+func (s *Stackdriver) SetDefaultSeverity(sev Severity) {
+	atomic.StoreInt32(&s.printSeverity, int32(sev)+1)
+}
+
+// defaultSeverity returns severity of Print entries.
+func (s *Stackdriver) defaultSeverity() Severity {
+	if v := atomic.LoadInt32(&s.printSeverity); v != 0 {
+		return Severity(v - 1)
+	}
+	return logging.Info
+}
+
+// SetMinLevel sets minimum severity of entries to be logged.
+// Already derived loggers are not affected.
+func (s *Stackdriver) SetMinLevel(sev Severity) {
+	atomic.StoreInt32(&s.minLevel, int32(sev))
+}
+
+// enabled reports whether entry with severity sev passes minimum level.
+func (s *Stackdriver) enabled(sev Severity) bool {
+	return int32(sev) >= atomic.LoadInt32(&s.minLevel)
+}
+
+// Enabled reports whether entries with severity sev are emitted,
+// considering minimum level and rate limit, so that building expensive
+// payloads may be skipped. Sampling depends on the message, so sampled
+// loggers may still drop entries Enabled reports for.
+func (s *Stackdriver) Enabled(sev Severity) bool {
+	return s.enabled(sev) && (s.limiter == nil || !s.limiter.exhausted(sev))
+}
+
+// DebugEnabled reports whether debug entries are emitted, see Enabled.
+func (s *Stackdriver) DebugEnabled() bool { return s.Enabled(logging.Debug) }
+
+// allow reports whether entry with severity sev and message msg
+// passes minimum level, sampling and rate limit.
+func (s *Stackdriver) allow(sev Severity, msg string) bool {
+	return s.enabled(sev) && s.sample(sev, msg) && s.rateLimit(sev)
+}
+
+// sample reports whether entry passes sampling.
+func (s *Stackdriver) sample(sev Severity, msg string) bool {
+	if s.sampler == nil || s.sampler.sample(sev, msg) {
+		return true
+	}
+	if s.counters != nil {
+		atomic.AddUint64(&s.counters.sampled, 1)
+	}
+	return false
 }
 
 func (s *Stackdriver) log(sev Severity, msg string, args ...interface{}) {
-	s.Logger.Printf(msg, args...)
-	if s.gcpLogger != nil {
-		s.gcpLogger.Log(logging.Entry{
-			Severity:    sev,
-			Payload:     fmt.Sprintf(msg, args...),
-			Labels:      s.labels,
-			HTTPRequest: s.req,
-		})
+	if s.allow(sev, msg) {
+		s.logf(sev, msg, args...)
+	}
+}
+
+// logf logs formatted text message regardless of minimum level.
+func (s *Stackdriver) logf(sev Severity, msg string, args ...interface{}) {
+	s.emit(logging.Entry{
+		Severity: sev,
+		Payload:  fmt.Sprintf(msg, args...),
+	})
+}
+
+// output adds logger's labels, request, trace and error to entry e,
+// redacts, validates and truncates its payload, prints it and writes
+// it to sinks. Labels e already has are merged with logger's ones, its
+// request, trace and timestamp are kept. The entry to be sent to GCP
+// is returned, or error when it's dropped as invalid.
+func (s *Stackdriver) output(e logging.Entry) (logging.Entry, error) {
+	if text, ok := e.Payload.(string); ok && (len(s.fields) > 0 || s.err != nil || s.errorReporting != nil && reportable(e.Severity)) {
+		e.Payload = map[string]interface{}{"message": text}
+	}
+	if p, ok := e.Payload.(map[string]interface{}); ok {
+		for k, v := range p {
+			p[k] = s.fieldValue(v)
+		}
+		if len(s.group) > 0 {
+			p = groupPayload(p, s.group)
+			e.Payload = p
+		}
+		mergeFields(p, s.fields)
+		if s.err != nil {
+			for k, v := range errorFields(s.err) {
+				p[k] = v
+			}
+		}
+		s.resolveLazy(p)
+		if s.errorReporting != nil && reportable(e.Severity) {
+			reportError(s.errorReporting, p)
+		}
+		if s.replace != nil {
+			p = replaceFields(s.replace, p)
+			e.Payload = p
+		}
+		if s.redactor != nil {
+			p = s.redactor.redact(p)
+			e.Payload = p
+		}
+		if s.validate != nil {
+			if err := s.validatePayload(e.Severity, p); err != nil {
+				return e, err
+			}
+		}
+	}
+	if s.maxPayloadSize > 0 {
+		e.Payload = truncatePayload(e.Payload, s.maxPayloadSize)
+	}
+	if p, ok := e.Payload.(map[string]interface{}); ok && s.keys != nil {
+		e.Payload = s.renameKeys(p)
 	}
+	if len(e.Labels) > 0 {
+		// labels of the entry take precedence over logger's ones
+		l := copyLabels(s.labels, len(e.Labels))
+		for k, v := range e.Labels {
+			l[k] = v
+		}
+		s.sanitizeLabels(l)
+		e.Labels = l
+	} else {
+		e.Labels = s.labels
+	}
+	if e.HTTPRequest == nil && s.req != nil && s.req.Request != nil {
+		// GCP client panics on HTTPRequest without Request
+		e.HTTPRequest = s.req
+	}
+	if e.Trace == "" {
+		e.Trace = s.trace
+		e.SpanID = s.spanID
+		e.TraceSampled = s.traceSampled
+	}
+	e.InsertID = s.entryInsertID()
+	e.Operation = s.entryOperation()
+	if e.Timestamp.IsZero() {
+		e.Timestamp = s.timestamp
+	}
+	if e.Timestamp.IsZero() {
+		// stamp entry at call time, not when buffered entry is sent
+		e.Timestamp = time.Now()
+	}
+	if s.sourceLocation {
+		e.SourceLocation = callerLocation()
+	}
+	if e.Severity >= s.stdoutLevel {
+		s.print(e)
+	}
+	if p, ok := e.Payload.(*protoPayload); ok {
+		e.Payload = p.st
+	}
+	s.writeSinks(e)
+	// entry passed level filters and validation, so it's written
+	s.counters.emit(e.Severity)
+	return e, nil
+}
+
+// emit prepares entry e, prints it, writes it to sinks and sends it to GCP.
+func (s *Stackdriver) emit(e logging.Entry) {
+	if s.discarded(e.Severity) {
+		return
+	}
+	e, err := s.output(e)
+	if err != nil || s.gcpLogger == nil || e.Severity < s.gcpLevel {
+		return
+	}
+	gcpLogger := s.routeLogger(e.Severity)
+	if !s.synchronous && !s.isDraining() {
+		gcpLogger.Log(e)
+		return
+	}
+	// request context is often canceled by the time its last entry is
+	// logged, so only its values are kept
+	ctx, cancel := context.WithTimeout(valuesContext{s.Context()}, syncTimeout)
+	defer cancel()
+	if err := gcpLogger.LogSync(ctx, e); err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// discarded reports whether entry of severity sev is written nowhere:
+// neither printed, nor written to sinks, nor sent to GCP.
+func (s *Stackdriver) discarded(sev Severity) bool {
+	return (s.noStdout || sev < s.stdoutLevel) && len(s.sinks) == 0 &&
+		(s.gcpLogger == nil || sev < s.gcpLevel)
+}
+
+// syncTimeout bounds sending of entry by synchronous loggers.
+const syncTimeout = 30 * time.Second
+
+// print writes entry e to stdout logger, structured payloads are
+// printed in logger's format. Common and logger's labels are appended
+// as key=value pairs, or put under "labels" key
+// of JSON payloads. Nothing is written when stdout is disabled.
+func (s *Stackdriver) print(e logging.Entry) {
+	if s.noStdout {
+		return
+	}
+	if s.jsonLogger != nil {
+		s.printStructured(e)
+		return
+	}
+	var (
+		text   string
+		err    error
+		labels = s.entryLabels(e)
+	)
+	switch p := e.Payload.(type) {
+	case string:
+		text = appendLabels(p, labels)
+	case map[string]interface{}:
+		if s.format == FormatJSON && len(labels) > 0 {
+			// labels are kept in JSON object to keep it parsable
+			p = withLabels(p, labels)
+			text, err = formatText(s.format, p, s.payloadKey("message"))
+			break
+		}
+		text, err = formatText(s.format, p, s.payloadKey("message"))
+		text = appendLabels(text, labels)
+	case *protoPayload:
+		text = string(p.json)
+	default:
+		var b []byte
+		b, err = json.Marshal(p)
+		text = string(b)
+	}
+	if err != nil {
+		s.Error("failed to marshal", "err", err)
+		return
+	}
+	if s.Logger.Flags()&(log.Lshortfile|log.Llongfile) != 0 {
+		s.Logger.Output(callerDepth(), text)
+		return
+	}
+	s.Logger.Print(text)
 }
 
 // Log is doing structural logging with provided severity.
+// Entries below minimum level are dropped before payload is built,
+// so they don't allocate unless args need boxing.
 func (s *Stackdriver) Log(sev Severity, msg string, args ...interface{}) {
-	payload := formatPayload(msg, args...)
-	b, err := json.Marshal(payload)
+	if s.allow(sev, msg) {
+		s.logKV(sev, msg, args...)
+	}
+}
+
+// logKV does structural logging regardless of minimum level.
+func (s *Stackdriver) logKV(sev Severity, msg string, args ...interface{}) {
+	s.logPayload(sev, formatPayload(msg, args...))
+}
+
+// logPayload logs structured payload regardless of minimum level.
+func (s *Stackdriver) logPayload(sev Severity, payload map[string]interface{}) {
+	s.emit(logging.Entry{
+		Severity: sev,
+		Payload:  payload,
+	})
+}
+
+// LogWithLabels is like Log but adds labels to this entry only,
+// without deriving a logger with With:
+//
+//	l.LogWithLabels(logging.Info, gcplog.Labels{"user_id": id}, "signed in")
+func (s *Stackdriver) LogWithLabels(sev Severity, labels Labels, msg string, args ...interface{}) {
+	if !s.allow(sev, msg) {
+		return
+	}
+	s.emit(logging.Entry{
+		Severity: sev,
+		Payload:  formatPayload(msg, args...),
+		Labels:   labels,
+	})
+}
+
+// LogSyncResult logs entry regardless of minimum level, sampling and
+// rate limit, and waits until GCP accepts it, returning the error
+// of the API, e.g. for audit events which must not be lost. The entry
+// is printed and written to sinks as usual. ErrNotSent is returned
+// when GCP logging isn't enabled or sev is below WithGCPLevel, so
+// callers can tell the entry wasn't persisted. ErrInvalidPayload is
+// returned when the entry is dropped by WithPayloadValidator.
+func (s *Stackdriver) LogSyncResult(ctx context.Context, sev Severity, msg string, args ...interface{}) error {
+	if s.discarded(sev) {
+		return ErrNotSent
+	}
+	e, err := s.output(logging.Entry{
+		Severity: sev,
+		Payload:  formatPayload(msg, args...),
+	})
 	if err != nil {
-		s.Error("failed to marshal", "err", err)
-	} else {
-		s.Logger.Print(string(b))
+		return err
 	}
-	if s.gcpLogger != nil {
-		s.gcpLogger.Log(logging.Entry{
-			Severity:    sev,
-			Payload:     payload,
-			Labels:      s.labels,
-			HTTPRequest: s.req,
-		})
+	if s.gcpLogger == nil || e.Severity < s.gcpLevel {
+		return ErrNotSent
 	}
+	return s.routeLogger(e.Severity).LogSync(ctx, e)
 }
 
 func (s *Stackdriver) Fatal(args ...interface{})   { s.Fatalf(fmt.Sprint(args...)) }
 func (s *Stackdriver) Fatalln(args ...interface{}) { s.Fatalf(fmt.Sprintln(args...)) }
 
 func (s *Stackdriver) Fatalf(msg string, args ...interface{}) {
-	s.log(logging.Critical, msg, args...)
-	if s.gcpLogger != nil {
-		s.gcpLogger.Flush()
+	s.logf(logging.Critical, msg, args...)
+	s.Flush()
+	s.exitProcess(1)
+}
+
+// exitProcess calls exit function set with WithExitFunc or os.Exit.
+func (s *Stackdriver) exitProcess(code int) {
+	if s.exit != nil {
+		s.exit(code)
+		return
 	}
-	os.Exit(1)
+	os.Exit(code)
 }
 
 func (s *Stackdriver) Panic(args ...interface{})   { s.Panicf(fmt.Sprint(args...)) }
 func (s *Stackdriver) Panicln(args ...interface{}) { s.Panicf(fmt.Sprintln(args...)) }
 
 func (s *Stackdriver) Panicf(msg string, args ...interface{}) {
-	s.log(logging.Critical, msg, args...)
-	if s.gcpLogger != nil {
-		s.gcpLogger.Flush()
-	}
+	s.logf(logging.Critical, msg, args...)
+	s.Flush()
 	panic(fmt.Sprintf(msg, args...))
 }
 
@@ -242,16 +943,133 @@ func (s *Stackdriver) Error(msg string, args ...interface{}) {
 	s.Log(logging.Error, msg, args...)
 }
 
-// Crit sends critical log message followed by os.Exit(1).
+// Crit sends critical log message followed by os.Exit(1),
+// see WithExitFunc.
 func (s *Stackdriver) Crit(msg string, args ...interface{}) {
-	s.Log(logging.Critical, msg, args...)
-	s.gcpLogger.Flush()
-	os.Exit(1)
+	s.logKV(logging.Critical, msg, args...)
+	s.Flush()
+	s.exitProcess(1)
+}
+
+// WithInsertID returns logger setting InsertID of its first entry to id
+// and of next ones to id suffixed with entry number, e.g. "id-1".
+// GCP deduplicates entries with the same InsertID and timestamp, so
+// entries re-emitted on retry in the same order are stored once:
+//
+//	l.WithInsertID(jobID + "-done").Info("job done")
+func (s *Stackdriver) WithInsertID(id string) ExtendedLogger {
+	c := s.clone()
+	c.insertID = id
+	c.insertSeq = new(uint64)
+	return c
+}
+
+// entryInsertID returns InsertID of the next entry set with WithInsertID.
+func (s *Stackdriver) entryInsertID() string {
+	if s.insertID == "" {
+		return ""
+	}
+	n := atomic.AddUint64(s.insertSeq, 1) - 1
+	if n == 0 {
+		return s.insertID
+	}
+	return s.insertID + "-" + strconv.FormatUint(n, 10)
+}
+
+// WithTimestamp returns logger setting Timestamp of entries to t instead
+// of time of the call, e.g. to backfill historical events.
+func (s *Stackdriver) WithTimestamp(t time.Time) ExtendedLogger {
+	c := s.clone()
+	c.timestamp = t
+	return c
 }
 
+// GCPLogger returns underlying GCP logger of the default log or nil when
+// logging to stdout only.
+// Entries logged with it directly bypass labels, formatting, filtering
+// and stdout mirroring of s. Common labels and resource still apply.
+func (s *Stackdriver) GCPLogger() *logging.Logger {
+	return s.gcpLogger
+}
+
+// Flush logs pending WithRateLimit summaries and sends buffered entries
+// to GCP. It may block for long when GCP is unreachable, use
+// FlushContext to bound the wait.
 func (s *Stackdriver) Flush() error {
-	if s.gcpLogger != nil {
-		return s.gcpLogger.Flush()
+	return s.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush but returns ctx.Err() when ctx is done
+// before buffered entries are sent, e.g. to bound shutdown time.
+// Flushing continues in background after that.
+func (s *Stackdriver) FlushContext(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.flushSuppressed()
+	if s.gcpLogger == nil {
+		return nil
+	}
+	if ctx.Done() == nil {
+		return s.flushAll()
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.flushAll() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain sends entries buffered by GCP client and waits until they are
+// acknowledged, e.g. before shutdown of audit-critical workloads.
+// It returns error when any entry failed to send since the previous
+// Flush or Drain, or ctx.Err() when ctx is done first. Entries logged
+// with s and loggers sharing its client after Drain is called are
+// sent synchronously as with WithSynchronous, so they aren't left
+// in the buffer either.
+func (s *Stackdriver) Drain(ctx context.Context) error {
+	if s == nil || s.gcpLogger == nil {
+		return nil
 	}
-	return nil
+	if s.draining != nil {
+		atomic.StoreInt32(s.draining, 1)
+	}
+	return s.FlushContext(ctx)
+}
+
+// isDraining reports whether Drain was called.
+func (s *Stackdriver) isDraining() bool {
+	return s.draining != nil && atomic.LoadInt32(s.draining) == 1
 }
+
+// Close logs pending WithRateLimit summaries, stops WithAutoFlush
+// flushing, closes file sinks, flushes buffered entries and closes
+// GCP client.
+// Loggers derived with With and WithRequest share the client,
+// so they must not be used after Close.
+func (s *Stackdriver) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.flushSuppressed()
+	var err error
+	for _, c := range s.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if s.client != nil {
+		if cerr := s.client.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Sync is an alias of Flush for compatibility with zap-like loggers.
+func (s *Stackdriver) Sync() error { return s.Flush() }
+
+var _ io.Closer = (*Stackdriver)(nil)
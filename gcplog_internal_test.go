@@ -0,0 +1,186 @@
+package gcplog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestWithDoesNotShareLabels(t *testing.T) {
+	parent := New(nil, WithWriter(ioutil.Discard), WithGCP(false)).With(Labels{"common": "1"}).(*Stackdriver)
+	a := parent.With(Labels{"a": "1"}).(*Stackdriver)
+	b := parent.With(Labels{"b": "1"}).(*Stackdriver)
+
+	if _, ok := a.labels["b"]; ok {
+		t.Errorf("a sees b's label: %v", a.labels)
+	}
+	if _, ok := b.labels["a"]; ok {
+		t.Errorf("b sees a's label: %v", b.labels)
+	}
+	if len(parent.labels) != 1 {
+		t.Errorf("parent labels changed: %v", parent.labels)
+	}
+	if a.labels["common"] != "1" || b.labels["common"] != "1" {
+		t.Errorf("children lost parent label: %v, %v", a.labels, b.labels)
+	}
+}
+
+func TestDerivedLoggersInheritContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "v")
+	l := New(nil, WithWriter(ioutil.Discard), WithGCP(false)).WithContext(ctx)
+	l = l.With(Labels{"a": "1"}).WithRequest(nil)
+	if got := l.(*Stackdriver).Context().Value(key{}); got != "v" {
+		t.Errorf("context value = %v, want v", got)
+	}
+}
+
+func TestFormatPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		args []interface{}
+		want map[string]interface{}
+	}{
+		{"empty", nil, map[string]interface{}{"message": "msg"}},
+		{"pairs", []interface{}{"a", 1, "b", "2"}, map[string]interface{}{"message": "msg", "a": 1, "b": "2"}},
+		{"odd", []interface{}{"a", 1, "dangling"}, map[string]interface{}{"message": "msg", "a": 1, extraKey: "dangling"}},
+		{"int key", []interface{}{42, "v"}, map[string]interface{}{"message": "msg", "42": "v"}},
+		{"message key", []interface{}{"message", "m"}, map[string]interface{}{"message": "msg", messageFieldKey: "m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatPayload("msg", tt.args...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("formatPayload = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Stackdriver{Logger: log.New(&buf, "", 0)}
+	s.errorHandler(&config{})(errors.New("quota exceeded"))
+	if buf.String() != "GCP logging failed: quota exceeded\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	var got error
+	s.errorHandler(&config{onError: func(err error) { got = err }})(errors.New("boom"))
+	if got == nil || got.Error() != "boom" {
+		t.Errorf("custom handler got %v", got)
+	}
+}
+
+type stackErr struct{ error }
+
+func (stackErr) StackTrace() []uintptr { return nil }
+
+func TestErrorFields(t *testing.T) {
+	base := errors.New("base")
+	err := fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", base))
+	got := errorFields(err)
+	want := map[string]interface{}{
+		errorKey:      "outer: inner: base",
+		errorChainKey: []string{"inner: base", "base"},
+		errorCausesKey: []interface{}{map[string]interface{}{
+			errorKey:       "inner: base",
+			errorCausesKey: []interface{}{map[string]interface{}{errorKey: "base"}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errorFields = %v, want %v", got, want)
+	}
+
+	if _, ok := errorFields(stackErr{base})[stackTraceKey]; !ok {
+		t.Error("expected stack trace for error with StackTrace method")
+	}
+}
+
+// joinErr is errors.Join result for toolchains before Go 1.20.
+type joinErr []error
+
+func (e joinErr) Error() string   { return "joined" }
+func (e joinErr) Unwrap() []error { return e }
+
+func TestErrorValue(t *testing.T) {
+	if got := errorValue(errors.New("boom")); got != "boom" {
+		t.Errorf("errorValue = %v, want boom", got)
+	}
+	err := fmt.Errorf("save: %w", joinErr{errors.New("disk full"), fmt.Errorf("retry: %w", errors.New("timeout"))})
+	got := errorValue(err)
+	want := map[string]interface{}{
+		errorKey: "save: joined",
+		errorCausesKey: []interface{}{map[string]interface{}{
+			errorKey: "joined",
+			errorCausesKey: []interface{}{
+				map[string]interface{}{errorKey: "disk full"},
+				map[string]interface{}{
+					errorKey:       "retry: timeout",
+					errorCausesKey: []interface{}{map[string]interface{}{errorKey: "timeout"}},
+				},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errorValue = %v, want %v", got, want)
+	}
+	if chain := errorChain(err); !reflect.DeepEqual(chain, []string{"joined", "disk full", "retry: timeout", "timeout"}) {
+		t.Errorf("errorChain = %q", chain)
+	}
+
+	var buf bytes.Buffer
+	l := &Stackdriver{Logger: log.New(&buf, "", 0)}
+	l.Error("failed", "err", errors.New("boom"))
+	if want := `{"err":"boom","message":"failed"}` + "\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWithError(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Stackdriver{Logger: log.New(&buf, "", 0)}
+	l.WithError(errors.New("boom")).Error("failed")
+	if want := `{"error":"boom","message":"failed"}` + "\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	l.WithError(errors.New("boom")).Printf("printf %d", 1)
+	if want := `{"error":"boom","message":"printf 1"}` + "\n"; buf.String() != want {
+		t.Errorf("Printf output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestAddLabel(t *testing.T) {
+	parent := New(nil, WithWriter(ioutil.Discard), WithGCP(false)).With(Labels{"a": "1"}).(*Stackdriver)
+	sibling := parent.WithRequest(nil).(*Stackdriver)
+
+	parent.AddLabel("b", "2")
+	parent.SetLabels(Labels{"c": "3"})
+	if len(parent.labels) != 3 {
+		t.Errorf("unexpected labels %v", parent.labels)
+	}
+	if len(sibling.labels) != 1 {
+		t.Errorf("sibling sees in-place labels: %v", sibling.labels)
+	}
+
+	child := parent.WithRequest(nil).(*Stackdriver)
+	parent.AddLabel("d", "4")
+	if _, ok := child.labels["d"]; ok {
+		t.Errorf("child derived before AddLabel sees its label: %v", child.labels)
+	}
+}
+
+func TestCommonLabelsAreCopied(t *testing.T) {
+	cl := Labels{"app": "a"}
+	s := New(cl, WithWriter(ioutil.Discard))
+	cl["app"] = "b"
+	if s.commonLabels["app"] != "a" {
+		t.Errorf("common labels changed with caller's map: %v", s.commonLabels)
+	}
+}
@@ -1,9 +1,26 @@
 package gcplog_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
 
 	"github.com/velppa/gcplog"
 )
@@ -15,7 +32,17 @@ func TestStandardLoggerSatisfiesInterface(t *testing.T) {
 }
 
 func TestLogsAreSubmittedToGCP(t *testing.T) {
-	l := gcplog.New(gcplog.Labels{"module": "test"})
+	srv, opts := gcplog.NewTestServer()
+	defer srv.Close()
+	l, err := gcplog.NewWithError(gcplog.Labels{"module": "test"},
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithMinLevel(logging.Debug),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
 	l.Printf("foo: %s", "bar")
 	l.Debug("Test message from TestLogsAreSubmittedToGCP test",
 		"number", 1,
@@ -23,4 +50,632 @@ func TestLogsAreSubmittedToGCP(t *testing.T) {
 		"slice", []int{1, 2, 3},
 	)
 	l.Flush()
+
+	entries := srv.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	if e := entries[0]; e.GetTextPayload() != "foo: bar" || e.Severity != logtypepb.LogSeverity(logging.Info) {
+		t.Errorf("unexpected Printf entry %v", e)
+	}
+	e := entries[1]
+	if e.Severity != logtypepb.LogSeverity(logging.Debug) || e.Labels["module"] != "test" {
+		t.Errorf("unexpected Debug entry %v", e)
+	}
+	if n := e.GetJsonPayload().GetFields()["number"].GetNumberValue(); n != 1 {
+		t.Errorf("number = %v, want 1", n)
+	}
+}
+
+func TestCloseWithoutGCP(t *testing.T) {
+	var s gcplog.Stackdriver
+	if err := s.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+}
+
+func TestNewWithErrorReportsMissingEnv(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig, gcplog.EnvMetadataHost)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	os.Setenv(gcplog.EnvMetadataHost, strings.TrimPrefix(srv.URL, "http://"))
+
+	l, err := gcplog.NewWithError(gcplog.Labels{"module": "test"})
+	if !errors.Is(err, gcplog.ErrNoProjectID) {
+		t.Fatalf("expected ErrNoProjectID, got %v", err)
+	}
+	if l == nil {
+		t.Fatal("expected stdout-only logger, got nil")
+	}
+	l.Printf("foo: %s", "bar")
+}
+
+func TestProjectIDDoesNotRequireCredentialsFile(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	l, err := gcplog.NewWithError(nil, gcplog.WithProjectID("test-project"))
+	if err != nil && !errors.Is(err, gcplog.ErrClient) {
+		t.Fatalf("expected nil or ErrClient, got %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+}
+
+// unsetenv unsets env variables and restores them after the test.
+func unsetenv(t *testing.T, keys ...string) {
+	for _, k := range keys {
+		k := k
+		if v, ok := os.LookupEnv(k); ok {
+			t.Cleanup(func() { os.Setenv(k, v) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(k) })
+		}
+		os.Unsetenv(k)
+	}
+}
+
+func TestWithWriter(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l := gcplog.New(gcplog.Labels{"app": "app", "module": "test"}, gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.Printf("foo: %s", "bar")
+	l.Info("hello", "k", "v")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "app test ") {
+			t.Errorf("line %q has no prefix", line)
+		}
+	}
+	if !strings.HasSuffix(lines[0], "foo: bar app=app module=test") {
+		t.Errorf("unexpected Printf output %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `{"k":"v","labels":{"app":"app","module":"test"},"message":"hello"}`) {
+		t.Errorf("unexpected Info output %q", lines[1])
+	}
+}
+
+func TestWithoutGCP(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l, err := gcplog.NewWithError(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	if err != nil {
+		t.Fatalf("expected no error without GCP, got %v", err)
+	}
+	if l.GCPLogger() != nil {
+		t.Error("expected no GCP logger")
+	}
+	l.Info("hello")
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("expected entry in output, got %q", buf.String())
+	}
+}
+
+func TestStdFlagsAndPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(gcplog.Labels{"app": "app"},
+		gcplog.WithGCP(false),
+		gcplog.WithWriter(&buf),
+		gcplog.WithStdFlags(log.Lshortfile|log.Lmsgprefix),
+		gcplog.WithPrefix("svc: "),
+	)
+	l.Info("hello")
+	l.Named("db").Printf("query")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "gcplog_test.go:") || !strings.HasSuffix(lines[0], `svc: {"labels":{"app":"app"},"message":"hello"}`) {
+		t.Errorf("unexpected output %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "gcplog_test.go:") || !strings.HasSuffix(lines[1], "svc: db query app=app logger=db") {
+		t.Errorf("unexpected Named output %q", lines[1])
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithMinLevel(logging.Warning))
+	l.Info("info")
+	l.Printf("printf")
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output, got %q", buf.String())
+	}
+	l.Warn("warn")
+	if !strings.Contains(buf.String(), "warn") {
+		t.Fatalf("expected warn output, got %q", buf.String())
+	}
+
+	buf.Reset()
+	l.SetMinLevel(logging.Error)
+	l.Warn("warn")
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output, got %q", buf.String())
+	}
+	if l.DebugEnabled() || l.Enabled(logging.Warning) || !l.Enabled(logging.Error) {
+		t.Error("Enabled must reflect minimum level")
+	}
+}
+
+func TestSetDefaultSeverity(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithStructuredStdout(), gcplog.WithMinLevel(logging.Default))
+	l.SetDefaultSeverity(logging.Warning)
+	derived := l.With(gcplog.Labels{"k": "v"})
+	l.SetDefaultSeverity(logging.Default)
+
+	for _, tt := range []struct {
+		l    gcplog.Logger
+		want string
+	}{{derived, "WARNING"}, {l, "DEFAULT"}} {
+		buf.Reset()
+		tt.l.Printf("hello")
+		if got := structuredLine(t, buf.Bytes())["severity"]; got != tt.want {
+			t.Errorf("severity = %v, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestNop(t *testing.T) {
+	l := gcplog.NewNop()
+	if l.With(gcplog.Labels{"a": "1"}) != l || l.WithRequest(nil) != l {
+		t.Error("derived nop logger must be the same")
+	}
+	l.Info("info", "k", "v")
+	l.Printf("foo: %s", "bar")
+}
+
+func TestTestLogger(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	l.With(gcplog.Labels{"x": "y"}).Error("boom", "code", 42)
+	l.Crit("crit")
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	e := entries[0]
+	if e.Severity != logging.Error || e.Message != "boom" || e.Labels["x"] != "y" || e.Fields["code"] != 42 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if entries[1].Severity != logging.Critical {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+
+	rec.Reset()
+	l.Group("db").WithFields(map[string]interface{}{"host": "h"}).Group("pool").Info("query", "size", 2)
+	want := map[string]interface{}{"db": map[string]interface{}{"host": "h", "pool": map[string]interface{}{"size": 2}}}
+	if got := rec.Entries()[0].Fields; !reflect.DeepEqual(got, want) {
+		t.Errorf("grouped fields = %v, want %v", got, want)
+	}
+}
+
+func TestCritWithoutGCPExits(t *testing.T) {
+	if os.Getenv("GCPLOG_TEST_CRIT") == "1" {
+		l := &gcplog.Stackdriver{Logger: log.New(os.Stderr, "", 0)}
+		l.Crit("crit")
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCritWithoutGCPExits$")
+	cmd.Env = append(os.Environ(), "GCPLOG_TEST_CRIT=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("expected exit code 1, got %v: %s", err, out)
+	}
+	if strings.Contains(string(out), "panic") {
+		t.Errorf("Crit panicked: %s", out)
+	}
+}
+
+func TestStructuredStdout(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(gcplog.Labels{"app": "app"}, gcplog.WithWriter(&buf), gcplog.WithStructuredStdout())
+	l.With(gcplog.Labels{"k": "v"}).Warn("hello", "n", 1)
+
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{
+		"severity":                      "WARNING",
+		"message":                       "hello",
+		"n":                             1.0,
+		"logging.googleapis.com/labels": map[string]interface{}{"app": "app", "k": "v"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+}
+
+// structuredLine returns JSON entry line b without timestamp,
+// checking it's present.
+func structuredLine(t *testing.T, b []byte) map[string]interface{} {
+	t.Helper()
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal %q failed: %s", b, err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(got["timestamp"])); err != nil {
+		t.Errorf("bad timestamp in %q: %s", b, err)
+	}
+	delete(got, "timestamp")
+	return got
+}
+
+func TestStructuredHTTPRequestAndSourceLocation(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithStructuredStdout(), gcplog.WithSourceLocation(true))
+	r := httptest.NewRequest(http.MethodGet, "/items?id=1", nil)
+	l.WithRequest(&logging.HTTPRequest{Request: r, Status: 404, Latency: 1500 * time.Millisecond}).
+		WithTrace("t", "s").Info("hello")
+
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{
+		"requestMethod": "GET",
+		"requestUrl":    "/items?id=1",
+		"protocol":      "HTTP/1.1",
+		"status":        404.0,
+		"latency":       "1.500000000s",
+	}
+	if !reflect.DeepEqual(got["httpRequest"], want) {
+		t.Errorf("httpRequest = %v, want %v", got["httpRequest"], want)
+	}
+	loc, _ := got["logging.googleapis.com/sourceLocation"].(map[string]interface{})
+	if !strings.HasSuffix(fmt.Sprint(loc["file"]), "gcplog_test.go") || loc["line"] == "0" {
+		t.Errorf("unexpected source location %v", loc)
+	}
+	if got["logging.googleapis.com/trace"] == nil {
+		t.Errorf("expected trace in %v", got)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := map[string]gcplog.Severity{
+		"debug":    logging.Debug,
+		"INFO":     logging.Info,
+		"warn":     logging.Warning,
+		"Warning":  logging.Warning,
+		"error":    logging.Error,
+		"crit":     logging.Critical,
+		"critical": logging.Critical,
+	}
+	for name, want := range tests {
+		got, err := gcplog.ParseSeverity(name)
+		if err != nil || got != want {
+			t.Errorf("ParseSeverity(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := gcplog.ParseSeverity("verbose"); err == nil {
+		t.Error("expected error for unknown severity")
+	}
+}
+
+func TestDerivedLoggersUseWriter(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.With(gcplog.Labels{"k": "v"}).Info("with")
+	l.WithRequest(nil).Info("request")
+	l.WithContext(context.Background()).Info("context")
+	for _, msg := range []string{"with", "request", "context"} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Errorf("output %q has no %q", buf.String(), msg)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := &gcplog.Stackdriver{Logger: log.New(&buf, "", 0)}
+	l.InfoFields("hello",
+		gcplog.String("s", "v"),
+		gcplog.Int("i", 1),
+		gcplog.Bool("b", true),
+		gcplog.Err(errors.New("boom")),
+		gcplog.Any("a", []int{1, 2}),
+	)
+	want := `{"a":[1,2],"b":true,"error":"boom","i":1,"message":"hello","s":"v"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	l.ErrorFields("failed", gcplog.Err(fmt.Errorf("query: %w", errors.New("timeout"))))
+	want = `{"error":{"causes":[{"error":"timeout"}],"error":"query: timeout"},"message":"failed"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWithMessageKey(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithMessageKey("msg"), gcplog.WithErrorKey("err"))
+	l.WithError(errors.New("boom")).Info("failed", "msg", "kept")
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{"msg": "failed", "msg_field": "kept", "err": "boom", "severity": "INFO"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+
+	buf.Reset()
+	l = gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithStdFlags(0),
+		gcplog.WithFormat(gcplog.FormatPlain), gcplog.WithMessageKey("text"))
+	l.Info("hello", "a", 1)
+	if got, want := buf.String(), "hello a=1\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithMinLevel(logging.Debug))
+	stop := l.Timer("query")
+	time.Sleep(10 * time.Millisecond)
+	stop()
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	if got := structuredLine(t, lines[0]); got["message"] != "query started" || got["severity"] != "DEBUG" {
+		t.Errorf("unexpected start entry %v", got)
+	}
+	got := structuredLine(t, lines[1])
+	if d, ok := got["duration_ms"].(float64); got["message"] != "query finished" || !ok || d < 10 {
+		t.Errorf("unexpected finish entry %v", got)
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.WithFields(map[string]interface{}{"attempt": 1, "user": "bob"}).
+		WithFields(map[string]interface{}{"attempt": 2}).
+		Info("retry", "user", "alice")
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{"attempt": 2.0, "message": "retry", "severity": "INFO", "user": "alice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+}
+
+type ctxKey string
+
+func TestWithContextField(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false),
+		gcplog.WithContextField("request_id", ctxKey("request")),
+		gcplog.WithContextField("user_id", ctxKey("user")))
+	ctx := context.WithValue(context.Background(), ctxKey("request"), "r1")
+	l.WithContext(ctx).Info("handled", "status", 200)
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{"message": "handled", "severity": "INFO", "status": 200.0, "request_id": "r1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+
+	buf.Reset()
+	l.Info("unbound")
+	if got := structuredLine(t, buf.Bytes()); got["request_id"] != nil {
+		t.Errorf("context fields leaked to parent: %v", got)
+	}
+}
+
+func TestWithContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false),
+		gcplog.WithContextFields(ctxKey("request_id"), ctxKey("user_id")))
+	ctx := context.WithValue(context.Background(), ctxKey("request_id"), "r1")
+	ctx = context.WithValue(ctx, ctxKey("user_id"), "u1")
+	l.WithContext(ctx).Info("handled")
+	got := structuredLine(t, buf.Bytes())
+	if got["request_id"] != "r1" || got["user_id"] != "u1" {
+		t.Errorf("unexpected entry %v", got)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithStdoutLevel(logging.Warning))
+	calls := 0
+	dump := gcplog.Lazy(func() interface{} {
+		calls++
+		return "big"
+	})
+	l.Debug("dropped", "dump", dump)
+	bound := l.WithFields(map[string]interface{}{"dump": dump})
+	bound.Info("filtered")
+	if calls != 0 {
+		t.Fatalf("lazy value of dropped entry computed %d times", calls)
+	}
+	bound.Warn("kept")
+	l.Warn("kept", "dump", dump)
+	if calls != 2 {
+		t.Errorf("lazy values computed %d times, want 2", calls)
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if got := structuredLine(t, line); got["dump"] != "big" {
+			t.Errorf("unexpected entry %v", got)
+		}
+	}
+}
+
+func TestWithReadableValues(t *testing.T) {
+	at := time.Date(2024, 5, 1, 15, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		opts []gcplog.Option
+		want map[string]interface{}
+	}{
+		{nil, map[string]interface{}{"took": 1.5e9, "at": "2024-05-01T15:04:05Z"}},
+		{[]gcplog.Option{gcplog.WithReadableValues(time.Kitchen)}, map[string]interface{}{"took": "1.5s", "at": "3:04PM"}},
+	} {
+		var buf bytes.Buffer
+		opts := append(tc.opts, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+		l := gcplog.New(nil, opts...)
+		l.WithFields(map[string]interface{}{"took": 1500 * time.Millisecond}).Info("done", "at", at)
+		got := structuredLine(t, buf.Bytes())
+		if got["took"] != tc.want["took"] || got["at"] != tc.want["at"] {
+			t.Errorf("entry = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	db := l.WithFields(map[string]interface{}{"user": "bob"}).Group("db")
+	pool := db.WithFields(map[string]interface{}{"host": "h", "port": 1}).Group("pool")
+	pool.Info("query", "size", 2)
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{
+		"message":  "query",
+		"severity": "INFO",
+		"user":     "bob",
+		"db":       map[string]interface{}{"host": "h", "port": 1.0, "pool": map[string]interface{}{"size": 2.0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+
+	buf.Reset()
+	db.Info("ping")
+	if got := structuredLine(t, buf.Bytes()); got["db"] != nil {
+		t.Errorf("empty group must be omitted, got %v", got)
+	}
+}
+
+func TestLogProtoStructuredStdout(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.LogProto(logging.Warning, &logpb.LogEntrySourceLocation{File: "main.go", Line: 42})
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{"file": "main.go", "line": "42", "severity": "WARNING"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+}
+
+func TestPanicFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := &gcplog.Stackdriver{Logger: log.New(&buf, "", 0)}
+	defer func() {
+		if v := recover(); v != "crash" {
+			t.Errorf("expected panic with message, got %v", v)
+		}
+		if want := `{"message":"crash","order":"42"}` + "\n"; buf.String() != want {
+			t.Errorf("output = %q, want %q", buf.String(), want)
+		}
+	}()
+	l.PanicFields("crash", gcplog.String("order", "42"))
+}
+
+func TestDerivedLoggersPreserveConfig(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l := gcplog.New(nil,
+		gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithMinLevel(logging.Warning),
+		gcplog.WithFormat(gcplog.FormatLogfmt),
+		gcplog.WithRedactedKeys("password"),
+	)
+	child := l.With(gcplog.Labels{"k": "v"}).WithRequest(nil).WithContext(context.Background()).WithTrace("t", "s")
+	child.Info("info")
+	if buf.Len() != 0 {
+		t.Fatalf("child must filter below min level, got %q", buf.String())
+	}
+	child.Warn("warn", "password", "secret")
+	if want := "message=warn password=[REDACTED] k=v\n"; !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("output = %q, want suffix %q", buf.String(), want)
+	}
+}
+
+func TestStackdriverSatisfiesExtendedLogger(t *testing.T) {
+	var l gcplog.ExtendedLogger = &gcplog.Stackdriver{Logger: log.New(ioutil.Discard, "", 0)}
+	l.Debug("debug")
+	if err := l.Flush(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSyncAndCloseAreNilSafe(t *testing.T) {
+	var l *gcplog.Stackdriver
+	var c io.Closer = l
+	if err := l.Sync(); err != nil {
+		t.Error(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	unsetenv(t, gcplog.EnvConfig)
+	var buf bytes.Buffer
+	l := gcplog.New(gcplog.Labels{"app": "app"}, gcplog.WithWriter(&buf), gcplog.WithStructuredStdout())
+	l.Named("parent").Named("child").Info("hello")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	labels, _ := got["logging.googleapis.com/labels"].(map[string]interface{})
+	if labels["logger"] != "parent.child" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+
+	buf.Reset()
+	l = gcplog.New(gcplog.Labels{"app": "app"}, gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.Named("parent").Named("child").Printf("hello")
+	if !strings.HasPrefix(buf.String(), "app parent.child ") {
+		t.Errorf("unexpected prefix in %q", buf.String())
+	}
+}
+
+func TestGCPLoggerWithoutGCP(t *testing.T) {
+	l := &gcplog.Stackdriver{}
+	if l.GCPLogger() != nil {
+		t.Error("expected nil GCP logger")
+	}
+}
+
+func TestDisabledLevelDoesNotAllocate(t *testing.T) {
+	l := gcplog.New(nil, gcplog.WithWriter(ioutil.Discard), gcplog.WithGCP(false))
+	if n := testing.AllocsPerRun(100, func() { l.Debug("query", "rows", 42, "table", "users") }); n != 0 {
+		t.Errorf("disabled Debug allocates %v times", n)
+	}
+}
+
+func BenchmarkDisabledDebug(b *testing.B) {
+	l := gcplog.New(nil, gcplog.WithWriter(ioutil.Discard), gcplog.WithGCP(false))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Debug("query", "rows", 42, "table", "users")
+	}
+}
+
+func BenchmarkInfo(b *testing.B) {
+	l := gcplog.New(nil, gcplog.WithWriter(ioutil.Discard), gcplog.WithGCP(false))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Info("query", "rows", 42, "table", "users")
+	}
+}
+
+func BenchmarkInfoStructured(b *testing.B) {
+	l := gcplog.New(nil, gcplog.WithWriter(ioutil.Discard), gcplog.WithGCP(false), gcplog.WithStructuredStdout())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Info("query", "rows", 42, "table", "users")
+	}
 }
@@ -4,5 +4,11 @@ go 1.15
 
 require (
 	cloud.google.com/go/logging v1.1.0
+	github.com/sirupsen/logrus v1.8.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.uber.org/zap v1.19.1
+	google.golang.org/api v0.30.0
 	google.golang.org/genproto v0.0.0-20200828030656-73b5761be4c5
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,87 @@
+package gcplog
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns gRPC interceptor logging each RPC
+// with l after it's handled. Request-scoped logger labeled with peer
+// metadata is available to handlers via FromContext.
+func UnaryServerInterceptor(l ExtendedLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		reqLogger := l.With(peerLabels(ctx)).WithContext(ctx)
+		resp, err := handler(NewContext(ctx, reqLogger), req)
+
+		code := status.Code(err)
+		args := []interface{}{
+			"method", info.FullMethod,
+			"code", code.String(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			args = append(args, "err", err.Error())
+		}
+		reqLogger.Log(SeverityForCode(code), info.FullMethod, args...)
+		return resp, err
+	}
+}
+
+// RecoveryUnaryServerInterceptor returns gRPC interceptor recovering from
+// panics in handlers. Panic is logged with l at Critical severity with
+// stack trace, entries are flushed and then panic is re-raised when
+// repanic is set, otherwise the RPC fails with codes.Internal.
+func RecoveryUnaryServerInterceptor(l ExtendedLogger, repanic bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			logPanic(l.With(peerLabels(ctx)).WithContext(ctx), v)
+			if repanic {
+				panic(v)
+			}
+			err = status.Error(codes.Internal, "internal error")
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// SeverityForCode maps gRPC status code to Severity:
+// OK is Info, client errors are Warning and server errors are Error.
+func SeverityForCode(code codes.Code) Severity {
+	switch code {
+	case codes.OK:
+		return logging.Info
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.ResourceExhausted,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return logging.Warning
+	default:
+		return logging.Error
+	}
+}
+
+// peerLabels returns labels describing RPC peer.
+func peerLabels(ctx context.Context) Labels {
+	labels := Labels{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		labels["peer"] = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			labels["user_agent"] = strings.Join(ua, " ")
+		}
+	}
+	return labels
+}
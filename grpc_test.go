@@ -0,0 +1,73 @@
+package gcplog_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+	_, err := gcplog.UnaryServerInterceptor(l)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		gcplog.FromContext(ctx).Info("handling")
+		return nil, status.Error(codes.Internal, "boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	e := entries[1]
+	if e.Severity != logging.Error || e.Fields["code"] != "Internal" || e.Labels["peer"] != "10.0.0.1:1" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestSeverityForCode(t *testing.T) {
+	tests := map[codes.Code]gcplog.Severity{
+		codes.OK:       logging.Info,
+		codes.NotFound: logging.Warning,
+		codes.Internal: logging.Error,
+		codes.Unknown:  logging.Error,
+	}
+	for code, want := range tests {
+		if got := gcplog.SeverityForCode(code); got != want {
+			t.Errorf("severity for %s = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRecoveryUnaryServerInterceptor(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+	panicking := func(context.Context, interface{}) (interface{}, error) { panic("boom") }
+
+	_, err := gcplog.RecoveryUnaryServerInterceptor(l, false)(context.Background(), nil, info, panicking)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("unexpected error %v", err)
+	}
+	entries := rec.Entries()
+	if len(entries) != 1 || entries[0].Severity != logging.Critical || entries[0].Message != "panic: boom" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("expected re-panic, got %v", v)
+		}
+	}()
+	gcplog.RecoveryUnaryServerInterceptor(l, true)(context.Background(), nil, info, panicking)
+}
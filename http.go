@@ -0,0 +1,178 @@
+package gcplog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying logger l.
+func NewContext(ctx context.Context, l ExtendedLogger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns logger stored in ctx with NewContext
+// or logger discarding entries if there is none.
+func FromContext(ctx context.Context) ExtendedLogger {
+	if l, ok := ctx.Value(ctxKey{}).(ExtendedLogger); ok {
+		return l
+	}
+	return NewNop()
+}
+
+// Middleware returns net/http middleware logging each request with l
+// after it's served, with severity by response status as mapped by
+// SeverityForStatus. Request-scoped logger bound to the request and its
+// context is available to handlers via FromContext.
+func Middleware(l ExtendedLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			reqLogger := l.WithRequest(&logging.HTTPRequest{Request: r}).WithContext(r.Context())
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r.WithContext(NewContext(r.Context(), reqLogger)))
+
+			latency := time.Since(start)
+			hr := HTTPRequestFromStdlib(r, rw.status, latency)
+			hr.ResponseSize = rw.size
+			reqLogger.WithRequest(hr).Log(SeverityForStatus(rw.status), fmt.Sprintf("%s %s", r.Method, r.URL.Path), "status", rw.status, "latency_ms", latency.Milliseconds())
+		})
+	}
+}
+
+// SeverityForStatus maps HTTP response status code to Severity:
+// server errors (5xx) are Error, client errors (4xx) are Warning,
+// informational (1xx), success (2xx) and redirection (3xx) are Info.
+func SeverityForStatus(code int) Severity {
+	switch {
+	case code >= 500:
+		return logging.Error
+	case code >= 400:
+		return logging.Warning
+	default:
+		return logging.Info
+	}
+}
+
+// Recoverer returns net/http middleware recovering from panics in
+// handlers. Panic is logged with l at Critical severity with stack trace
+// and the request, entries are flushed and then panic is re-raised when
+// repanic is set, otherwise the client gets 500 Internal Server Error.
+// http.ErrAbortHandler is re-raised without logging.
+func Recoverer(l ExtendedLogger, repanic bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logPanic(l.WithRequest(&logging.HTTPRequest{Request: r}).WithContext(r.Context()), v)
+				if repanic {
+					panic(v)
+				}
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logPanic logs recovered value v at Critical severity with stack trace
+// and flushes l.
+func logPanic(l ExtendedLogger, v interface{}) {
+	l.Log(logging.Critical, fmt.Sprintf("panic: %v", v), stackTraceKey, string(debug.Stack()))
+	l.Flush()
+}
+
+// HTTPRequestFromStdlib returns HTTPRequest for r served with status in latency.
+// Method, URL, user agent and referer are taken by GCP client from r,
+// remote IP is taken from X-Forwarded-For header or r.RemoteAddr.
+func HTTPRequestFromStdlib(r *http.Request, status int, latency time.Duration) *logging.HTTPRequest {
+	hr := &logging.HTTPRequest{
+		Request:  r,
+		Status:   status,
+		Latency:  latency,
+		RemoteIP: remoteIP(r),
+	}
+	if r.ContentLength > 0 {
+		hr.RequestSize = r.ContentLength
+	}
+	return hr
+}
+
+// remoteIP returns client IP of request r.
+func remoteIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// responseWriter records status and size of response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if underlying writer does.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if underlying writer does,
+// e.g. for websockets. Hijacked connection status is reported as 101.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status = http.StatusSwitchingProtocols
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Push implements http.Pusher if underlying writer does.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
@@ -0,0 +1,140 @@
+package gcplog_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestMiddleware(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	h := gcplog.Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gcplog.FromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tea", nil))
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Message != "handling" {
+		t.Errorf("unexpected handler entry %+v", entries[0])
+	}
+	access := entries[1]
+	if access.Message != "GET /tea" || access.HTTPRequest == nil {
+		t.Fatalf("unexpected access entry %+v", access)
+	}
+	if access.HTTPRequest.Status != http.StatusTeapot || access.HTTPRequest.ResponseSize != 15 {
+		t.Errorf("unexpected request %+v", access.HTTPRequest)
+	}
+	if access.Severity != logging.Warning {
+		t.Errorf("severity = %v, want Warning", access.Severity)
+	}
+}
+
+func TestSeverityForStatus(t *testing.T) {
+	tests := map[int]gcplog.Severity{
+		http.StatusContinue:            logging.Info,
+		http.StatusOK:                  logging.Info,
+		http.StatusFound:               logging.Info,
+		http.StatusNotModified:         logging.Info,
+		http.StatusBadRequest:          logging.Warning,
+		http.StatusNotFound:            logging.Warning,
+		http.StatusInternalServerError: logging.Error,
+		http.StatusServiceUnavailable:  logging.Error,
+	}
+	for code, want := range tests {
+		if got := gcplog.SeverityForStatus(code); got != want {
+			t.Errorf("SeverityForStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestHTTPRequestFromStdlib(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("body"))
+	r.RemoteAddr = "10.0.0.1:1234"
+	hr := gcplog.HTTPRequestFromStdlib(r, http.StatusCreated, time.Second)
+	if hr.Request != r || hr.Status != http.StatusCreated || hr.Latency != time.Second ||
+		hr.RequestSize != 4 || hr.RemoteIP != "10.0.0.1" {
+		t.Errorf("unexpected request %+v", hr)
+	}
+
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+	if ip := gcplog.HTTPRequestFromStdlib(r, 0, 0).RemoteIP; ip != "1.2.3.4" {
+		t.Errorf("remote IP = %q, want 1.2.3.4", ip)
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	gcplog.Recoverer(l, false)(h).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	entries := rec.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v", entries)
+	}
+	e := entries[0]
+	if e.Severity != logging.Critical || e.Message != "panic: boom" || e.HTTPRequest == nil ||
+		!strings.Contains(fmt.Sprint(e.Fields["stack_trace"]), "TestRecoverer") {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("expected re-panic, got %v", v)
+		}
+	}()
+	gcplog.Recoverer(l, true)(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	rec, l := gcplog.NewTestLogger()
+	srv := httptest.NewServer(gcplog.Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %s", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d, want 101", resp.StatusCode)
+	}
+	entries := rec.Entries()
+	if len(entries) != 1 || entries[0].Fields["status"] != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestMiddlewarePushNotSupported(t *testing.T) {
+	_, l := gcplog.NewTestLogger()
+	h := gcplog.Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := w.(http.Pusher).Push("/app.js", nil); err != http.ErrNotSupported {
+			t.Errorf("push error = %v, want http.ErrNotSupported", err)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,79 @@
+package gcplog
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/logging"
+)
+
+// Limits of entry labels, see
+// https://cloud.google.com/logging/quotas#log-limits
+const (
+	maxLabelKeyLen   = 512
+	maxLabelValueLen = 64 * 1024
+)
+
+// sanitizeLabels fixes labels in place so GCP doesn't reject entries
+// with them: labels with empty or over-length keys are dropped, invalid
+// UTF-8 is replaced and over-length values are truncated. It returns
+// descriptions of changes made.
+func sanitizeLabels(labels Labels) []string {
+	var changes []string
+	for k, v := range labels {
+		key := strings.ToValidUTF8(k, "�")
+		switch {
+		case key == "":
+			changes = append(changes, "dropped label with empty key")
+			delete(labels, k)
+			continue
+		case len(key) > maxLabelKeyLen:
+			changes = append(changes, fmt.Sprintf("dropped label %.32q...: key longer than %d bytes", key, maxLabelKeyLen))
+			delete(labels, k)
+			continue
+		case key != k:
+			changes = append(changes, fmt.Sprintf("replaced invalid UTF-8 in label key %q", key))
+			delete(labels, k)
+		}
+		value := strings.ToValidUTF8(v, "�")
+		if len(value) > maxLabelValueLen {
+			value = truncateUTF8(value, maxLabelValueLen)
+			changes = append(changes, fmt.Sprintf("truncated label %q to %d bytes", key, maxLabelValueLen))
+		} else if value != v {
+			changes = append(changes, fmt.Sprintf("replaced invalid UTF-8 in label %q", key))
+		}
+		labels[key] = value
+	}
+	return changes
+}
+
+// truncateUTF8 returns at most n first bytes of s not splitting runes.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// sanitizeLabels sanitizes labels warning about changes.
+func (s *Stackdriver) sanitizeLabels(labels Labels) {
+	for _, c := range sanitizeLabels(labels) {
+		s.warnLabel(c)
+	}
+}
+
+// warnLabel logs Warning entry about label change c, once per change
+// for s and loggers derived from it, as the same labels are usually
+// passed on every request.
+func (s *Stackdriver) warnLabel(c string) {
+	if s.labelWarnings != nil {
+		if _, warned := s.labelWarnings.LoadOrStore(c, true); warned {
+			return
+		}
+	}
+	s.warner().logKV(logging.Warning, "label sanitized", "change", c)
+}
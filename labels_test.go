@@ -0,0 +1,53 @@
+package gcplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeLabels(t *testing.T) {
+	labels := Labels{
+		"":                       "empty",
+		strings.Repeat("k", 513): "long key",
+		"bad\xffkey":             "v",
+		"long":                   strings.Repeat("é", maxLabelValueLen),
+		"ok":                     "fine",
+	}
+	changes := sanitizeLabels(labels)
+	if len(changes) != 4 {
+		t.Errorf("expected 4 changes, got %q", changes)
+	}
+	if len(labels) != 3 || labels["ok"] != "fine" || labels["bad�key"] != "v" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if v := labels["long"]; len(v) != maxLabelValueLen || !strings.HasSuffix(v, "é") {
+		t.Errorf("value must be truncated at rune boundary, got %d bytes", len(v))
+	}
+}
+
+func TestWithSanitizesLabels(t *testing.T) {
+	var buf bytes.Buffer
+	s := New(nil, WithWriter(&buf), WithGCP(false), WithStructuredStdout())
+	l := s.With(Labels{"": "x", "k": "v"}).(*Stackdriver)
+	if len(l.labels) != 1 || l.labels["k"] != "v" {
+		t.Errorf("unexpected labels %v", l.labels)
+	}
+	l.With(Labels{"": "y"})
+	s.AddLabel("", "z")
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("warning must be single JSON line, got %q", buf.String())
+	}
+	if got["severity"] != "WARNING" || got["change"] != "dropped label with empty key" {
+		t.Errorf("unexpected warning %v", got)
+	}
+
+	buf.Reset()
+	s = New(nil, WithWriter(&buf), WithGCP(false), WithStdout(false))
+	s.With(Labels{"": "x"})
+	if buf.Len() != 0 {
+		t.Errorf("warning must respect WithStdout(false), got %q", buf.String())
+	}
+}
@@ -0,0 +1,94 @@
+// Package logrusgcp adapts gcplog.Stackdriver logger to logrus, so that
+// applications using logrus write entries to GCP. It's a separate
+// package to not make every user of gcplog depend on logrus.
+package logrusgcp
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/logging"
+	"github.com/sirupsen/logrus"
+
+	"github.com/velppa/gcplog"
+)
+
+// Hook is logrus.Hook forwarding entries to Stackdriver logger.
+// Entry fields become payload fields, except ones listed in LabelFields
+// which become labels.
+type Hook struct {
+	sd *gcplog.Stackdriver
+
+	// LabelFields lists logrus fields sent as labels instead of payload.
+	LabelFields []string
+}
+
+var _ logrus.Hook = (*Hook)(nil)
+
+// NewHook returns logrus hook writing to sd. Entries of Fatal and
+// Panic levels are flushed before logrus exits or panics.
+func NewHook(sd *gcplog.Stackdriver) *Hook {
+	return &Hook{sd: sd}
+}
+
+// SeverityForLevel maps logrus level to GCP severity.
+func SeverityForLevel(level logrus.Level) gcplog.Severity {
+	switch level {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		return logging.Debug
+	case logrus.InfoLevel:
+		return logging.Info
+	case logrus.WarnLevel:
+		return logging.Warning
+	case logrus.ErrorLevel:
+		return logging.Error
+	case logrus.FatalLevel, logrus.PanicLevel:
+		return logging.Critical
+	default:
+		return logging.Default
+	}
+}
+
+// Levels implements logrus.Hook. All levels are accepted, filtering by
+// minimum level of the logger happens in Fire.
+func (h *Hook) Levels() []logrus.Level { return logrus.AllLevels }
+
+// Fire implements logrus.Hook. Field named "message" is logged under
+// "message_field" like with gcplog Log, errors are logged with
+// their causes.
+func (h *Hook) Fire(e *logrus.Entry) error {
+	sev := SeverityForLevel(e.Level)
+	if !h.sd.Enabled(sev) {
+		return nil
+	}
+	s := h.sd
+	if e.Context != nil {
+		s = s.WithContext(e.Context).(*gcplog.Stackdriver)
+	}
+	args := make([]interface{}, 0, 2*len(e.Data))
+	var labels gcplog.Labels
+	for k, v := range e.Data {
+		if h.isLabel(k) {
+			if labels == nil {
+				labels = gcplog.Labels{}
+			}
+			labels[k] = fmt.Sprint(v)
+			continue
+		}
+		args = append(args, k, v)
+	}
+	s.LogWithLabels(sev, labels, e.Message, args...)
+	if e.Level <= logrus.FatalLevel {
+		// logrus exits or panics right after firing hooks
+		return s.Flush()
+	}
+	return nil
+}
+
+func (h *Hook) isLabel(k string) bool {
+	for _, l := range h.LabelFields {
+		if l == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package logrusgcp_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/sirupsen/logrus"
+
+	"github.com/velppa/gcplog"
+	"github.com/velppa/gcplog/logrusgcp"
+)
+
+func TestHook(t *testing.T) {
+	var buf bytes.Buffer
+	sd := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithStdFlags(0))
+	hook := logrusgcp.NewHook(sd)
+	hook.LabelFields = []string{"tenant"}
+
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	l.SetLevel(logrus.TraceLevel)
+	l.AddHook(hook)
+
+	l.Debug("hidden")
+	err := fmt.Errorf("query: %w", errors.New("timeout"))
+	l.WithFields(logrus.Fields{"user": "bob", "tenant": "acme", "message": "field", "err": err}).Warn("hello")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug entry must be filtered by min level: %q", out)
+	}
+	want := `{"err":{"causes":[{"error":"timeout"}],"error":"query: timeout"},"labels":{"tenant":"acme"},"message":"hello","message_field":"field","user":"bob"}`
+	if !strings.Contains(out, want) {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestHookFlushesPanic(t *testing.T) {
+	srv, opts := gcplog.NewTestServer()
+	defer srv.Close()
+	sd, err := gcplog.NewWithError(nil,
+		gcplog.WithStdout(false),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithFlushSettings(gcplog.FlushSettings{DelayThreshold: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sd.Close()
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	l.AddHook(logrusgcp.NewHook(sd))
+
+	func() {
+		defer func() { recover() }()
+		l.Panic("boom")
+	}()
+
+	entries := srv.Entries()
+	if len(entries) != 1 || entries[0].GetJsonPayload().GetFields()["message"].GetStringValue() != "boom" {
+		t.Errorf("panic entry must be flushed before logrus panics, got %v", entries)
+	}
+}
+
+func TestSeverityForLevel(t *testing.T) {
+	for level, want := range map[logrus.Level]gcplog.Severity{
+		logrus.TraceLevel: logging.Debug,
+		logrus.InfoLevel:  logging.Info,
+		logrus.WarnLevel:  logging.Warning,
+		logrus.ErrorLevel: logging.Error,
+		logrus.FatalLevel: logging.Critical,
+		logrus.PanicLevel: logging.Critical,
+	} {
+		if got := logrusgcp.SeverityForLevel(level); got != want {
+			t.Errorf("%s: got %s, want %s", level, got, want)
+		}
+	}
+}
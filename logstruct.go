@@ -0,0 +1,36 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// payloadKey holds LogStruct value which isn't JSON object.
+const payloadKey = "payload"
+
+// LogStruct logs v marshaled to JSON as structured payload merged
+// with msg under "message" key. Value that isn't a JSON object is put
+// under "payload" key. If v can't be marshaled, entry is logged
+// with its fmt representation and the marshal error.
+func (s *Stackdriver) LogStruct(sev Severity, msg string, v interface{}) {
+	if s.allow(sev, msg) {
+		s.logPayload(sev, structPayload(msg, v))
+	}
+}
+
+func structPayload(msg string, v interface{}) map[string]interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return map[string]interface{}{
+			"message":  msg,
+			payloadKey: fmt.Sprintf("%+v", v),
+			errorKey:   fmt.Sprintf("marshal payload failed: %s", err),
+		}
+	}
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		payload = map[string]interface{}{payloadKey: json.RawMessage(b)}
+	}
+	payload["message"] = msg
+	return payload
+}
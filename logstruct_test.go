@@ -0,0 +1,41 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStructPayload(t *testing.T) {
+	type order struct {
+		ID    int      `json:"id"`
+		Items []string `json:"items"`
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"struct", order{1, []string{"a"}}, `{"id":1,"items":["a"],"message":"msg"}`},
+		{"slice", []int{1, 2}, `{"message":"msg","payload":[1,2]}`},
+		{"unsupported", make(chan int), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := structPayload("msg", tt.v)
+			if tt.want == "" {
+				if p["message"] != "msg" || p[errorKey] == nil || p[payloadKey] == nil {
+					t.Errorf("unexpected fallback payload %v", p)
+				}
+				return
+			}
+			var got, want interface{}
+			b, _ := json.Marshal(p)
+			json.Unmarshal(b, &got)
+			json.Unmarshal([]byte(tt.want), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("payload = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package gcplog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvMetadataHost is the name of env variable overriding
+// GCE metadata server host, e.g. for tests.
+const EnvMetadataHost = "GCE_METADATA_HOST"
+
+const metadataHost = "metadata.google.internal"
+
+// metadataClient has short timeout, so outside of GCP
+// project ID lookup doesn't hang.
+var metadataClient = &http.Client{Timeout: time.Second}
+
+// getMetadataProjectID returns GCP project id from GCE/GKE metadata server.
+func getMetadataProjectID() (string, error) {
+	id, err := getMetadata("project/project-id")
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", fmt.Errorf("metadata server returned empty project id")
+	}
+	return id, nil
+}
+
+// getMetadata returns value of metadata server path,
+// e.g. "instance/zone".
+func getMetadata(path string) (string, error) {
+	host := os.Getenv(EnvMetadataHost)
+	if host == "" {
+		host = metadataHost
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("query metadata server failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read metadata response failed: %s", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Labels set by WithRuntimeLabels.
+const (
+	labelInstanceID     = "instance_id"
+	labelZone           = "zone"
+	labelRegion         = "region"
+	labelService        = "service"
+	labelServiceVersion = "service_version"
+)
+
+// runtimeLabels returns labels describing deployment process runs in,
+// read from env variables set by Cloud Run and from metadata server.
+// Labels which can't be found out are omitted.
+func runtimeLabels() Labels {
+	labels := Labels{}
+	if service := os.Getenv("K_SERVICE"); service != "" {
+		labels[labelService] = service
+	}
+	if revision := os.Getenv("K_REVISION"); revision != "" {
+		labels[labelServiceVersion] = revision
+	}
+	id, err := getMetadata("instance/id")
+	if err != nil {
+		// not on GCP, other lookups would only wait for timeout
+		return labels
+	}
+	if id != "" {
+		labels[labelInstanceID] = id
+	}
+	// zone is "projects/123/zones/us-central1-a" on GCE and GKE,
+	// Cloud Run only has region "projects/123/regions/us-central1"
+	if zone, err := getMetadata("instance/zone"); err == nil && zone != "" {
+		zone = zone[strings.LastIndex(zone, "/")+1:]
+		labels[labelZone] = zone
+		if i := strings.LastIndex(zone, "-"); i > 0 {
+			labels[labelRegion] = zone[:i]
+		}
+	} else if region, err := getMetadata("instance/region"); err == nil && region != "" {
+		labels[labelRegion] = region[strings.LastIndex(region, "/")+1:]
+	}
+	return labels
+}
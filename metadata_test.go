@@ -0,0 +1,102 @@
+package gcplog
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubMetadata points metadata lookups to h for the duration of the test.
+func stubMetadata(t *testing.T, h http.HandlerFunc) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	for _, k := range []string{EnvConfig, EnvMetadataHost} {
+		k := k
+		if v, ok := os.LookupEnv(k); ok {
+			t.Cleanup(func() { os.Setenv(k, v) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(k) })
+		}
+	}
+	os.Unsetenv(EnvConfig)
+	os.Setenv(EnvMetadataHost, strings.TrimPrefix(srv.URL, "http://"))
+}
+
+func TestProjectIDFromMetadata(t *testing.T) {
+	stubMetadata(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/project/project-id" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("metadata-project"))
+	})
+	id, err := getGCPProjectID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "metadata-project" {
+		t.Errorf("project id = %q, want metadata-project", id)
+	}
+}
+
+func TestProjectIDMetadataUnavailable(t *testing.T) {
+	stubMetadata(t, http.NotFound)
+	if _, err := getGCPProjectID(); !errors.Is(err, ErrNoProjectID) {
+		t.Errorf("expected ErrNoProjectID, got %v", err)
+	}
+}
+
+func TestNewWarnsGCPDisabled(t *testing.T) {
+	stubMetadata(t, http.NotFound)
+	var buf bytes.Buffer
+	l := New(nil, WithWriter(&buf))
+	if l.GCPEnabled() {
+		t.Error("GCP must be disabled")
+	}
+	if out := buf.String(); !strings.Contains(out, "GCP logging disabled") || !strings.Contains(out, "WithProjectID") {
+		t.Errorf("unexpected warning %q", out)
+	}
+}
+
+func TestRuntimeLabels(t *testing.T) {
+	for _, k := range []string{"K_SERVICE", "K_REVISION"} {
+		k := k
+		if v, ok := os.LookupEnv(k); ok {
+			t.Cleanup(func() { os.Setenv(k, v) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(k) })
+		}
+		os.Unsetenv(k)
+	}
+	stubMetadata(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/id":
+			w.Write([]byte("123"))
+		case "/computeMetadata/v1/instance/zone":
+			w.Write([]byte("projects/1/zones/us-central1-a"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	os.Setenv("K_REVISION", "svc-1")
+
+	want := Labels{"instance_id": "123", "zone": "us-central1-a", "region": "us-central1", "service_version": "svc-1"}
+	if got := runtimeLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("runtimeLabels = %v, want %v", got, want)
+	}
+	if got := addRuntimeLabels(Labels{"zone": "custom"}); got["zone"] != "custom" || got["instance_id"] != "123" {
+		t.Errorf("explicit labels must take precedence, got %v", got)
+	}
+}
+
+func TestRuntimeLabelsWithoutMetadata(t *testing.T) {
+	stubMetadata(t, http.NotFound)
+	if got := runtimeLabels(); got[labelInstanceID] != "" || got[labelZone] != "" {
+		t.Errorf("unexpected labels %v", got)
+	}
+}
@@ -0,0 +1,35 @@
+package gcplog
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// loggerLabel is the label holding name of logger set with Named.
+const loggerLabel = "logger"
+
+// Named returns logger with name component appended to logger's name
+// with dot, e.g. "parent.child". The name is added to stdout prefix
+// and to "logger" label of entries.
+func (s *Stackdriver) Named(name string) ExtendedLogger {
+	if name == "" {
+		return s
+	}
+	c := s.clone()
+	if c.name == "" {
+		c.name = name
+	} else {
+		c.name = c.name + "." + name
+	}
+	prefix := strings.TrimSpace(strings.TrimSpace(s.prefix)+" "+c.name) + " "
+	out := s.out
+	if out == nil {
+		out = os.Stderr
+	}
+	c.Logger = log.New(out, prefix, s.Logger.Flags())
+	l := copyLabels(s.labels, 1)
+	l[loggerLabel] = c.name
+	c.labels = l
+	return c
+}
@@ -0,0 +1,47 @@
+package gcplog
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/logging"
+)
+
+// nop is ExtendedLogger discarding all entries.
+type nop struct{}
+
+// NewNop returns ExtendedLogger which discards all entries,
+// useful in tests. Fatal and Crit still exit and Panic still panics.
+func NewNop() ExtendedLogger { return nop{} }
+
+func (n nop) WithRequest(*logging.HTTPRequest) ExtendedLogger  { return n }
+func (n nop) WithContext(context.Context) ExtendedLogger       { return n }
+func (n nop) WithTrace(string, string) ExtendedLogger          { return n }
+func (n nop) WithError(error) ExtendedLogger                   { return n }
+func (n nop) WithFields(map[string]interface{}) ExtendedLogger { return n }
+func (n nop) Group(string) ExtendedLogger                      { return n }
+func (n nop) Named(string) ExtendedLogger                      { return n }
+func (n nop) With(map[string]string) ExtendedLogger            { return n }
+
+func (nop) Print(...interface{})          {}
+func (nop) Printf(string, ...interface{}) {}
+func (nop) Println(...interface{})        {}
+
+func (nop) Fatal(...interface{})          { os.Exit(1) }
+func (nop) Fatalf(string, ...interface{}) { os.Exit(1) }
+func (nop) Fatalln(...interface{})        { os.Exit(1) }
+
+func (nop) Panic(args ...interface{})              { panic(fmt.Sprint(args...)) }
+func (nop) Panicf(msg string, args ...interface{}) { panic(fmt.Sprintf(msg, args...)) }
+func (nop) Panicln(args ...interface{})            { panic(fmt.Sprintln(args...)) }
+
+func (nop) Log(Severity, string, ...interface{}) {}
+func (nop) Debug(string, ...interface{})         {}
+func (nop) Info(string, ...interface{})          {}
+func (nop) Warn(string, ...interface{})          {}
+func (nop) Error(string, ...interface{})         {}
+func (nop) Crit(string, ...interface{})          { os.Exit(1) }
+
+func (nop) Flush() error { return nil }
+func (nop) Close() error { return nil }
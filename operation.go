@@ -0,0 +1,47 @@
+package gcplog
+
+import (
+	"sync/atomic"
+
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+// operation is long-running operation entries are grouped by.
+type operation struct {
+	id       string
+	producer string
+	// started is set atomically by the first entry.
+	started int32
+}
+
+// WithOperation returns logger grouping entries into operation id
+// of producer, e.g. a job ID and the job name. The first entry logged
+// is marked as the first entry of operation, use EndOperation to log
+// the last one.
+func (s *Stackdriver) WithOperation(id, producer string) ExtendedLogger {
+	c := s.clone()
+	c.op = &operation{id: id, producer: producer}
+	c.opLast = false
+	return c
+}
+
+// EndOperation logs entry marked as the last entry of operation started
+// with WithOperation regardless of minimum level, sampling and rate limit.
+func (s *Stackdriver) EndOperation(sev Severity, msg string, args ...interface{}) {
+	c := s.clone()
+	c.opLast = true
+	c.logKV(sev, msg, args...)
+}
+
+// entryOperation returns operation of the next entry or nil.
+func (s *Stackdriver) entryOperation() *logpb.LogEntryOperation {
+	if s.op == nil {
+		return nil
+	}
+	return &logpb.LogEntryOperation{
+		Id:       s.op.id,
+		Producer: s.op.producer,
+		First:    atomic.CompareAndSwapInt32(&s.op.started, 0, 1),
+		Last:     s.opLast,
+	}
+}
@@ -0,0 +1,544 @@
+package gcplog
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+type config struct {
+	logName string
+	writer  io.Writer
+
+	projectID    string
+	logProjectID string
+
+	stdFlags  int
+	prefix    string
+	prefixSet bool
+
+	sourceLocation bool
+	minLevel       Severity
+	levelEnv       string
+	stdoutLevel    Severity
+	gcpLevel       Severity
+
+	structuredStdout bool
+
+	stdout bool
+	gcp    bool
+
+	resource *mrpb.MonitoredResource
+	format   Format
+
+	flush     FlushSettings
+	autoFlush time.Duration
+
+	redactor    *redactor
+	replace     ReplaceFunc
+	validate    func(map[string]interface{}) error
+	dropInvalid bool
+	keys        map[string]string
+	ctxFields   []contextField
+
+	onError func(error)
+
+	clientOpts []option.ClientOption
+
+	errorReporting *serviceContext
+
+	synchronous bool
+
+	sampler *sampler
+	limiter *rateLimiter
+
+	sinks   []Sink
+	closers []io.Closer
+	recent  *ringBuffer
+
+	maxPayloadSize int
+
+	runtimeLabels bool
+
+	exit func(int)
+
+	timeLayout string
+
+	routes []logRoute
+}
+
+// Option configures Stackdriver logger created with New.
+type Option func(*config)
+
+// WithLogName sets the name of GCP log entries are written to.
+// By default "app" common label is used.
+func WithLogName(name string) Option {
+	return func(c *config) { c.logName = name }
+}
+
+// WithWriter sets the destination of text output.
+// Logs are written to os.Stderr when w is nil or option is not used.
+func WithWriter(w io.Writer) Option {
+	return func(c *config) {
+		if w == nil {
+			w = os.Stderr
+		}
+		c.writer = w
+	}
+}
+
+// WithStdFlags sets log package flags of text output, log.LstdFlags
+// by default, e.g. log.Lmicroseconds or log.Lshortfile. File and line
+// are reported for the caller of the logger.
+func WithStdFlags(flags int) Option {
+	return func(c *config) { c.stdFlags = flags }
+}
+
+// WithPrefix sets prefix of text output lines. By default prefix is
+// made of "app" and "module" common labels.
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+		c.prefixSet = true
+	}
+}
+
+// WithProjectID sets GCP project the application runs in. It's used
+// in monitored resource and trace names and, unless WithLogProjectID
+// is used, logs are sent to it. When set, project is not read from
+// the file pointed by EnvConfig.
+func WithProjectID(id string) Option {
+	return func(c *config) { c.projectID = id }
+}
+
+// WithLogProjectID sets GCP project logs are sent to, e.g. central
+// logging project, when it differs from the project of credentials
+// or the one set with WithProjectID. Credentials must be granted
+// permission to write logs to it.
+func WithLogProjectID(id string) Option {
+	return func(c *config) { c.logProjectID = id }
+}
+
+// WithSourceLocation enables reporting caller file, line and function
+// as entry source location. It's disabled by default since
+// capturing the caller isn't free.
+func WithSourceLocation(enabled bool) Option {
+	return func(c *config) { c.sourceLocation = enabled }
+}
+
+// WithMinLevel sets minimum severity of entries to be logged,
+// logging.Info by default. Entries with lower severity are dropped, so
+// Debug and Default entries require WithMinLevel(logging.Default) or
+// LOG_LEVEL=default to be logged. Fatal and Crit entries are always
+// logged. Severity set in env variable named by WithLevelEnv takes
+// precedence.
+func WithMinLevel(sev Severity) Option {
+	return func(c *config) { c.minLevel = sev }
+}
+
+// WithStdoutLevel sets minimum severity of entries printed to
+// the writer. It applies on top of the minimum level, e.g.
+// WithMinLevel(logging.Debug) with WithGCPLevel(logging.Info) prints
+// Debug entries locally and sends only Info and above to GCP.
+func WithStdoutLevel(sev Severity) Option {
+	return func(c *config) { c.stdoutLevel = sev }
+}
+
+// WithGCPLevel sets minimum severity of entries sent to GCP,
+// see WithStdoutLevel.
+func WithGCPLevel(sev Severity) Option {
+	return func(c *config) { c.gcpLevel = sev }
+}
+
+// DefaultLevelEnv is the name of env variable minimum severity
+// is read from by default.
+const DefaultLevelEnv = "LOG_LEVEL"
+
+// WithLevelEnv sets the name of env variable minimum severity is read
+// from with ParseSeverity, DefaultLevelEnv by default, so verbosity
+// may be tuned without code changes. Invalid value is warned about
+// once per process and ignored. Empty name disables reading severity
+// from env.
+func WithLevelEnv(name string) Option {
+	return func(c *config) { c.levelEnv = name }
+}
+
+// WithStructuredStdout makes logger write entries as single-line
+// Cloud Logging JSON objects to the writer instead of sending them
+// to GCP API, so they are picked up by logging agent on Cloud Run or GKE.
+// Severity, labels, trace, source location, HTTP request and timestamp
+// are written under the special field names the agent recognizes.
+func WithStructuredStdout() Option {
+	return func(c *config) { c.structuredStdout = true }
+}
+
+// WithStdout enables or disables writing entries to the writer,
+// e.g. to avoid paying twice when logging agent also ingests stdout.
+// Entries are still sent to GCP. It's enabled by default.
+func WithStdout(enabled bool) Option {
+	return func(c *config) { c.stdout = enabled }
+}
+
+// WithGCP enables or disables sending entries to GCP API, e.g. for
+// local development. Entries are still written to the writer and
+// no GCP client is created. It's enabled by default.
+func WithGCP(enabled bool) Option {
+	return func(c *config) { c.gcp = enabled }
+}
+
+// WithRuntimeLabels adds common labels describing deployment: "service"
+// and "service_version" from K_SERVICE and K_REVISION env variables set
+// by Cloud Run, "instance_id", "zone" and "region" from metadata server.
+// Labels which can't be found out, e.g. outside of GCP, are omitted and
+// explicitly passed common labels take precedence.
+func WithRuntimeLabels() Option {
+	return func(c *config) { c.runtimeLabels = true }
+}
+
+// WithSeverityLog sends entries with severity sev and higher to GCP
+// log logName instead of the default one, e.g. for separate alerting
+// and retention of errors:
+//
+//	gcplog.New(cl, gcplog.WithSeverityLog(logging.Error, "app-errors"))
+//
+// Option may be used for several severities, an entry goes to the log
+// of the highest severity it reaches.
+func WithSeverityLog(sev Severity, logName string) Option {
+	return func(c *config) {
+		c.routes = addRoute(c.routes, logRoute{minSev: sev, logName: logName})
+	}
+}
+
+// WithExitFunc sets function Fatal, Crit and FatalFields call with
+// code 1 after flushing entries, os.Exit by default. It lets tests
+// record exit and applications run cleanup before exiting.
+func WithExitFunc(f func(code int)) Option {
+	return func(c *config) { c.exit = f }
+}
+
+// WithReadableValues makes durations logged as strings like "1.5s"
+// instead of nanoseconds and times formatted with layout, e.g.
+// time.Kitchen, instead of RFC 3339. Empty layout is time.RFC3339Nano.
+func WithReadableValues(timeLayout string) Option {
+	return func(c *config) {
+		if timeLayout == "" {
+			timeLayout = time.RFC3339Nano
+		}
+		c.timeLayout = timeLayout
+	}
+}
+
+// WithMonitoredResource sets monitored resource entries are logged with,
+// e.g. DetectResource or GKEResource result. Missing project_id label
+// is set to the project. By default ProjectResource is used.
+func WithMonitoredResource(r *mrpb.MonitoredResource) Option {
+	return func(c *config) { c.resource = r }
+}
+
+// WithFormat sets format structured entries are printed in
+// to the writer, FormatJSON by default.
+func WithFormat(f Format) Option {
+	return func(c *config) { c.format = f }
+}
+
+// FlushSettings configures buffering of GCP logger.
+// Zero values keep client defaults.
+type FlushSettings struct {
+	// EntryCountThreshold is max number of entries sent in one batch.
+	EntryCountThreshold int
+	// DelayThreshold is max time entries are buffered before sending.
+	DelayThreshold time.Duration
+	// BufferedByteLimit is max size of buffered entries, entries
+	// exceeding it are dropped.
+	BufferedByteLimit int
+}
+
+func (fs FlushSettings) loggerOptions() []logging.LoggerOption {
+	var opts []logging.LoggerOption
+	if fs.EntryCountThreshold > 0 {
+		opts = append(opts, logging.EntryCountThreshold(fs.EntryCountThreshold))
+	}
+	if fs.DelayThreshold > 0 {
+		opts = append(opts, logging.DelayThreshold(fs.DelayThreshold))
+	}
+	if fs.BufferedByteLimit > 0 {
+		opts = append(opts, logging.BufferedByteLimit(fs.BufferedByteLimit))
+	}
+	return opts
+}
+
+// WithAutoFlush makes logger flush buffered entries to GCP every
+// interval, bounding delivery latency of services which don't call
+// Flush until shutdown. Flushing is stopped by Close.
+func WithAutoFlush(interval time.Duration) Option {
+	return func(c *config) { c.autoFlush = interval }
+}
+
+// WithFlushSettings configures buffering of GCP logger. Short-lived jobs
+// want small thresholds for near-synchronous sending, high-throughput
+// services want larger buffers.
+func WithFlushSettings(fs FlushSettings) Option {
+	return func(c *config) { c.flush = fs }
+}
+
+// WithRedactedKeys makes values of payload keys matching one of keys
+// case-insensitively replaced with Redacted, including nested maps.
+func WithRedactedKeys(keys ...string) Option {
+	return func(c *config) {
+		r := c.ensureRedactor()
+		for _, k := range keys {
+			r.keys[strings.ToLower(k)] = true
+		}
+	}
+}
+
+// WithRedactedKeyPatterns is like WithRedactedKeys but matches keys
+// with regular expressions.
+func WithRedactedKeyPatterns(patterns ...*regexp.Regexp) Option {
+	return func(c *config) {
+		r := c.ensureRedactor()
+		r.patterns = append(r.patterns, patterns...)
+	}
+}
+
+// WithReplaceFields sets function applied to every field of structured
+// payloads, including fields of nested maps, before they are redacted
+// and emitted. Returning empty key drops the field.
+func WithReplaceFields(f ReplaceFunc) Option {
+	return func(c *config) { c.replace = f }
+}
+
+// WithPayloadValidator sets function checking structured payloads,
+// e.g. against organization's log schema. It runs after
+// WithReplaceFields and redaction, before payload is truncated and
+// keys are renamed with WithMessageKey and WithErrorKey. When f returns
+// error warning describing it is logged and the entry is still emitted
+// unless WithDropInvalidPayloads is used.
+func WithPayloadValidator(f func(map[string]interface{}) error) Option {
+	return func(c *config) { c.validate = f }
+}
+
+// WithDropInvalidPayloads makes entries rejected by WithPayloadValidator
+// function dropped after the warning is logged.
+func WithDropInvalidPayloads() Option {
+	return func(c *config) { c.dropInvalid = true }
+}
+
+// WithContextField adds value of context under key as field name to
+// entries of loggers bound to the context with WithContext or
+// NewWithContext, e.g. request and user IDs set by middleware.
+// Value is taken once when logger is bound to the context.
+func WithContextField(name string, key interface{}) Option {
+	return func(c *config) { c.ctxFields = append(c.ctxFields, contextField{name, key}) }
+}
+
+// WithContextFields is like WithContextField for several keys, fields
+// are named by keys formatted with fmt.Sprint, so keys should be
+// strings or types printing as their names, e.g.
+//
+//	type ctxKey string
+//	l := gcplog.New(nil, gcplog.WithContextFields(ctxKey("request_id"), ctxKey("user_id")))
+//
+// Use WithContextField when the key doesn't print as the wanted name.
+func WithContextFields(keys ...interface{}) Option {
+	return func(c *config) {
+		for _, k := range keys {
+			c.ctxFields = append(c.ctxFields, contextField{fmt.Sprint(k), k})
+		}
+	}
+}
+
+// WithMessageKey sets payload key message is logged under instead of
+// "message", e.g. "msg". Note Cloud Logging shows only "message" field
+// as the entry summary and Error Reporting requires it.
+func WithMessageKey(key string) Option {
+	return func(c *config) { c.renameKey("message", key) }
+}
+
+// WithErrorKey sets payload key error attached with WithError or Err
+// is logged under instead of "error", e.g. "err".
+func WithErrorKey(key string) Option {
+	return func(c *config) { c.renameKey(errorKey, key) }
+}
+
+func (c *config) renameKey(from, to string) {
+	if c.keys == nil {
+		c.keys = map[string]string{}
+	}
+	c.keys[from] = to
+}
+
+func (c *config) ensureRedactor() *redactor {
+	if c.redactor == nil {
+		c.redactor = &redactor{keys: map[string]bool{}}
+	}
+	return c.redactor
+}
+
+// WithErrorHandler sets function called when GCP client fails
+// to send entries in background, e.g. on auth expiry or quota,
+// or when a sink fails with ErrSink. By default errors are printed
+// to the writer.
+func WithErrorHandler(f func(error)) Option {
+	return func(c *config) { c.onError = f }
+}
+
+// WithSinks adds sinks every entry is written to in addition
+// to stdout and GCP.
+func WithSinks(sinks ...Sink) Option {
+	return func(c *config) { c.sinks = append(c.sinks, sinks...) }
+}
+
+// WithFileSink adds sink writing entries to file at path as Cloud
+// Logging JSON lines, the same ones WithStructuredStdout produces, e.g.
+// for on-prem deployments without access to GCP. The file is rotated
+// when it grows over maxSizeMB megabytes keeping maxBackups previous
+// files named path.1, path.2 and so on. Zero maxSizeMB disables
+// rotation. The file is closed by Close.
+func WithFileSink(path string, maxSizeMB, maxBackups int) Option {
+	return func(c *config) {
+		fs := newFileSink(path, maxSizeMB, maxBackups)
+		c.sinks = append(c.sinks, fs)
+		c.closers = append(c.closers, fs)
+	}
+}
+
+// WithRecentEntries makes logger keep n last entries in memory for
+// Recent and RecentHandler, e.g. for live debugging without querying
+// GCP. Entries aren't kept by default.
+func WithRecentEntries(n int) Option {
+	return func(c *config) {
+		if n <= 0 || c.recent != nil {
+			return
+		}
+		c.recent = newRingBuffer(n)
+		c.sinks = append(c.sinks, c.recent)
+	}
+}
+
+// WithClientOptions sets options GCP logging client is created with,
+// e.g. credentials, endpoint, gRPC dial options or connection pool.
+//
+// To log to the emulator or a fake server in tests, point the client
+// to it and disable authentication:
+//
+//	gcplog.New(cl,
+//		gcplog.WithProjectID("test-project"),
+//		gcplog.WithClientOptions(
+//			option.WithEndpoint("localhost:8085"),
+//			option.WithoutAuthentication(),
+//			option.WithGRPCDialOption(grpc.WithInsecure()),
+//		),
+//	)
+//
+// NewTestServer returns options for an in-process fake.
+func WithClientOptions(opts ...option.ClientOption) Option {
+	return func(c *config) { c.clientOpts = append(c.clientOpts, opts...) }
+}
+
+// WithErrorReporting makes Error and higher severity entries
+// formatted for GCP Error Reporting with service name and version.
+// Such entries are always logged as structured ones with stack trace.
+func WithErrorReporting(service, version string) Option {
+	return func(c *config) {
+		c.errorReporting = &serviceContext{Service: service, Version: version}
+	}
+}
+
+// WithSynchronous makes logger send each entry to GCP synchronously
+// with LogSync, blocking until it's acknowledged. It guarantees
+// durability of critical audit logs at the cost of a round trip to
+// GCP API on every call, so it shouldn't be used for high-volume logging.
+// Send errors are passed to the error handler.
+func WithSynchronous() Option {
+	return func(c *config) { c.synchronous = true }
+}
+
+// WithSampling makes logger keep first entries with the same severity
+// and message (format string for Printf) per second and every
+// thereafter-th entry after that, dropping the rest from both stdout
+// and GCP. Error and higher severity entries are never dropped.
+func WithSampling(first, thereafter int) Option {
+	return func(c *config) { c.sampler = newSampler(time.Second, first, thereafter) }
+}
+
+// WithRateLimit limits entries of severity sev to n per duration per,
+// protecting GCP quota from log storms. Excess entries are dropped from
+// both stdout and GCP and summarized with an entry like
+// "suppressed 5 warning entries" when the limit allows again, or on
+// Flush and Close, whichever comes first. Option may be used for several severities.
+func WithRateLimit(sev Severity, n int, per time.Duration) Option {
+	return func(c *config) {
+		if n <= 0 || per <= 0 {
+			return
+		}
+		if c.limiter == nil {
+			c.limiter = newRateLimiter()
+		}
+		c.limiter.setLimit(sev, n, per)
+	}
+}
+
+// WithMaxPayloadSize sets max size in bytes of JSON encoded entry
+// payload, DefaultMaxPayloadSize by default. Larger payloads are
+// replaced with a summary holding the message, "truncated": true and
+// the beginning of encoded payload, so that GCP doesn't reject them
+// with the whole batch. Zero or negative n disables the check.
+func WithMaxPayloadSize(n int) Option {
+	return func(c *config) { c.maxPayloadSize = n }
+}
+
+func newConfig(cl map[string]string, opts []Option) *config {
+	cfg := &config{
+		writer:         os.Stderr,
+		stdFlags:       log.LstdFlags,
+		minLevel:       logging.Info,
+		levelEnv:       DefaultLevelEnv,
+		stdout:         true,
+		gcp:            true,
+		maxPayloadSize: DefaultMaxPayloadSize,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.logName == "" {
+		cfg.logName = logName(cl)
+	}
+	return cfg
+}
+
+// levelEnvWarnings holds warnings about invalid env variables
+// already logged, as loggers are often created per request or module.
+var levelEnvWarnings sync.Map
+
+// applyLevelEnv sets minimum level from env variable, returning warning
+// when its value is invalid and wasn't warned about yet.
+func (c *config) applyLevelEnv() string {
+	if c.levelEnv == "" {
+		return ""
+	}
+	v := os.Getenv(c.levelEnv)
+	if v == "" {
+		return ""
+	}
+	sev, err := ParseSeverity(v)
+	if err != nil {
+		warning := fmt.Sprintf("ignored env var %s: %s", c.levelEnv, err)
+		if _, warned := levelEnvWarnings.LoadOrStore(warning, true); warned {
+			return ""
+		}
+		return warning
+	}
+	c.minLevel = sev
+	return ""
+}
@@ -0,0 +1,120 @@
+package gcplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+)
+
+func TestNewConfigLogName(t *testing.T) {
+	tests := []struct {
+		name string
+		cl   map[string]string
+		opts []Option
+		want string
+	}{
+		{"option", Labels{"app": "app"}, []Option{WithLogName("custom")}, "custom"},
+		{"app label", Labels{"app": "app"}, nil, "app"},
+		{"default", nil, nil, defaultLogName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newConfig(tt.cl, tt.opts).logName; got != tt.want {
+				t.Errorf("logName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewConfigNilWriter(t *testing.T) {
+	if w := newConfig(nil, []Option{WithWriter(nil)}).writer; w != os.Stderr {
+		t.Errorf("writer = %v, want os.Stderr", w)
+	}
+}
+
+func TestFlushSettingsLoggerOptions(t *testing.T) {
+	if n := len(FlushSettings{}.loggerOptions()); n != 0 {
+		t.Errorf("expected no options for zero settings, got %d", n)
+	}
+	fs := FlushSettings{EntryCountThreshold: 1, DelayThreshold: time.Millisecond, BufferedByteLimit: 1 << 20}
+	if n := len(fs.loggerOptions()); n != 3 {
+		t.Errorf("expected 3 options, got %d", n)
+	}
+}
+
+func TestWithClientOptions(t *testing.T) {
+	cfg := newConfig(nil, []Option{
+		WithClientOptions(option.WithoutAuthentication()),
+		WithClientOptions(option.WithEndpoint("localhost:8080")),
+	})
+	if len(cfg.clientOpts) != 2 {
+		t.Errorf("expected options to accumulate, got %d", len(cfg.clientOpts))
+	}
+}
+
+func resetLevelEnvWarnings() {
+	levelEnvWarnings.Range(func(k, _ interface{}) bool {
+		levelEnvWarnings.Delete(k)
+		return true
+	})
+}
+
+func TestLevelEnv(t *testing.T) {
+	if v, ok := os.LookupEnv(DefaultLevelEnv); ok {
+		t.Cleanup(func() { os.Setenv(DefaultLevelEnv, v) })
+	} else {
+		t.Cleanup(func() { os.Unsetenv(DefaultLevelEnv) })
+	}
+	resetLevelEnvWarnings()
+	tests := []struct {
+		name    string
+		env     string
+		opts    []Option
+		want    Severity
+		warning bool
+	}{
+		{"default", "", nil, logging.Info, false},
+		{"option", "", []Option{WithMinLevel(logging.Debug)}, logging.Debug, false},
+		{"env", "warn", []Option{WithMinLevel(logging.Debug)}, logging.Warning, false},
+		{"invalid", "loud", nil, logging.Info, true},
+		{"warned once", "loud", nil, logging.Info, false},
+		{"disabled", "error", []Option{WithLevelEnv("")}, logging.Info, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(DefaultLevelEnv, tt.env)
+			cfg := newConfig(nil, tt.opts)
+			warning := cfg.applyLevelEnv()
+			if cfg.minLevel != tt.want || (warning != "") != tt.warning {
+				t.Errorf("minLevel = %v, warning %q", cfg.minLevel, warning)
+			}
+		})
+	}
+}
+
+func TestLevelEnvWarning(t *testing.T) {
+	if v, ok := os.LookupEnv(DefaultLevelEnv); ok {
+		t.Cleanup(func() { os.Setenv(DefaultLevelEnv, v) })
+	} else {
+		t.Cleanup(func() { os.Unsetenv(DefaultLevelEnv) })
+	}
+	resetLevelEnvWarnings()
+	os.Setenv(DefaultLevelEnv, "noisy")
+	var buf bytes.Buffer
+	New(nil, WithWriter(&buf), WithGCP(false), WithStructuredStdout())
+	New(nil, WithWriter(&buf), WithGCP(false), WithStructuredStdout())
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected single JSON warning, got %q", buf.String())
+	}
+	if got["severity"] != "WARNING" || !strings.Contains(fmt.Sprint(got["message"]), "noisy") {
+		t.Errorf("unexpected warning %v", got)
+	}
+}
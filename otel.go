@@ -0,0 +1,19 @@
+package gcplog
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithSpanContext returns logger bound to ctx as with WithContext,
+// attaching trace and span of OpenTelemetry span active in ctx to entries.
+func (s *Stackdriver) WithSpanContext(ctx context.Context) ExtendedLogger {
+	c := s.WithContext(ctx).(*Stackdriver)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		c.trace = c.qualifyTrace(sc.TraceID().String())
+		c.spanID = sc.SpanID().String()
+		c.traceSampled = sc.IsSampled()
+	}
+	return c
+}
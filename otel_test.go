@@ -0,0 +1,31 @@
+package gcplog
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithSpanContext(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	l := (&Stackdriver{projectID: "p"}).WithSpanContext(ctx).(*Stackdriver)
+	if l.trace != "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736" || l.spanID != "00f067aa0ba902b7" || !l.traceSampled {
+		t.Errorf("trace = %q, span = %q, sampled = %v", l.trace, l.spanID, l.traceSampled)
+	}
+	if l.Context() != ctx {
+		t.Error("logger must be bound to ctx")
+	}
+
+	type ctxKey string
+	s := &Stackdriver{projectID: "p", ctxFields: []contextField{{"request_id", ctxKey("request")}}}
+	l = s.WithSpanContext(context.WithValue(ctx, ctxKey("request"), "r1")).(*Stackdriver)
+	if l.fields["request_id"] != "r1" || l.spanID != "00f067aa0ba902b7" {
+		t.Errorf("fields = %v, span = %q", l.fields, l.spanID)
+	}
+}
@@ -0,0 +1,38 @@
+package gcplog
+
+import (
+	"cloud.google.com/go/logging"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// protoPayload is payload of entry logged with LogProto, it keeps
+// JSON rendering of the message for stdout.
+type protoPayload struct {
+	st   *structpb.Struct
+	json []byte
+}
+
+// LogProto sends proto message msg as entry payload. The message is
+// marshaled once with protojson and passed to GCP client as a Struct,
+// so it isn't marshaled to JSON again. Payload processing like
+// WithFields, WithError and redaction doesn't apply to it.
+func (s *Stackdriver) LogProto(sev Severity, msg proto.Message) {
+	name := string(msg.ProtoReflect().Descriptor().FullName())
+	if !s.allow(sev, name) {
+		return
+	}
+	b, err := protojson.Marshal(msg)
+	if err == nil {
+		st := &structpb.Struct{}
+		if err = protojson.Unmarshal(b, st); err == nil {
+			s.emit(logging.Entry{
+				Severity: sev,
+				Payload:  &protoPayload{st: st, json: b},
+			})
+			return
+		}
+	}
+	s.Error("failed to marshal proto", "err", err, "type", name)
+}
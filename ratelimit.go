@@ -0,0 +1,131 @@
+package gcplog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter limits entries per severity with token buckets.
+type rateLimiter struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	buckets map[Severity]*bucket
+}
+
+type bucket struct {
+	capacity   float64
+	perToken   time.Duration
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{now: time.Now, buckets: map[Severity]*bucket{}}
+}
+
+func (r *rateLimiter) setLimit(sev Severity, n int, per time.Duration) {
+	r.buckets[sev] = &bucket{
+		capacity: float64(n),
+		perToken: per / time.Duration(n),
+		tokens:   float64(n),
+	}
+}
+
+// allow reports whether entry with severity sev is within the limit.
+// When it is, number of entries suppressed since the previous allowed
+// one is returned too.
+func (r *rateLimiter) allow(sev Severity) (ok bool, suppressed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.buckets[sev]
+	if b == nil {
+		return true, 0
+	}
+	now := r.now()
+	if !b.last.IsZero() {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.perToken)
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.suppressed++
+		return false, 0
+	}
+	b.tokens--
+	suppressed, b.suppressed = b.suppressed, 0
+	return true, suppressed
+}
+
+// takeSuppressed returns numbers of entries suppressed per severity
+// since the previous allowed entry and resets them.
+func (r *rateLimiter) takeSuppressed() map[Severity]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result map[Severity]int
+	for sev, b := range r.buckets {
+		if b.suppressed == 0 {
+			continue
+		}
+		if result == nil {
+			result = make(map[Severity]int, len(r.buckets))
+		}
+		result[sev], b.suppressed = b.suppressed, 0
+	}
+	return result
+}
+
+// exhausted reports whether entry with severity sev would be dropped
+// now, without consuming the limit.
+func (r *rateLimiter) exhausted(sev Severity) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.buckets[sev]
+	if b == nil || b.last.IsZero() {
+		return false
+	}
+	return b.tokens+float64(r.now().Sub(b.last))/float64(b.perToken) < 1
+}
+
+// rateLimit reports whether entry with severity sev passes rate limit,
+// logging summary of entries suppressed before it.
+func (s *Stackdriver) rateLimit(sev Severity) bool {
+	if s.limiter == nil {
+		return true
+	}
+	ok, suppressed := s.limiter.allow(sev)
+	if !ok {
+		if s.counters != nil {
+			atomic.AddUint64(&s.counters.rateLimited, 1)
+		}
+	}
+	if suppressed > 0 {
+		s.logSuppressed(sev, suppressed)
+	}
+	return ok
+}
+
+// flushSuppressed logs summaries of entries suppressed by rate limit
+// which no allowed entry followed yet. It's called by Flush and Close,
+// so with WithAutoFlush summaries are logged periodically too.
+func (s *Stackdriver) flushSuppressed() {
+	if s.limiter == nil {
+		return
+	}
+	for sev, n := range s.limiter.takeSuppressed() {
+		s.logSuppressed(sev, n)
+	}
+}
+
+// logSuppressed logs summary of n suppressed entries of severity sev.
+func (s *Stackdriver) logSuppressed(sev Severity, n int) {
+	s.logPayload(sev, map[string]interface{}{
+		"message":    fmt.Sprintf("suppressed %d %s entries", n, strings.ToLower(sev.String())),
+		"suppressed": n,
+	})
+}
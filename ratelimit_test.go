@@ -0,0 +1,56 @@
+package gcplog
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := newRateLimiter()
+	r.now = func() time.Time { return now }
+	r.setLimit(logging.Warning, 2, time.Minute)
+
+	var buf bytes.Buffer
+	s := &Stackdriver{Logger: log.New(&buf, "", 0), limiter: r}
+	for i := 0; i < 5; i++ {
+		s.Warn("warn")
+	}
+	if s.Enabled(logging.Warning) || !s.Enabled(logging.Info) {
+		t.Error("Enabled must report exhausted limit")
+	}
+	s.Info("info")
+	if n := strings.Count(buf.String(), `"message":"warn"`); n != 2 {
+		t.Errorf("expected 2 warnings, got %d in %q", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "info") {
+		t.Error("info must not be limited")
+	}
+
+	buf.Reset()
+	now = now.Add(30 * time.Second)
+	s.Warn("warn")
+	if !strings.Contains(buf.String(), "suppressed 3 warning entries") || !strings.Contains(buf.String(), `"message":"warn"`) {
+		t.Errorf("expected summary followed by warning, got %q", buf.String())
+	}
+
+	buf.Reset()
+	s.Warn("warn")
+	s.Warn("warn")
+	s.Flush()
+	if !strings.Contains(buf.String(), "suppressed 2 warning entries") {
+		t.Errorf("expected summary on flush, got %q", buf.String())
+	}
+	buf.Reset()
+	now = now.Add(time.Minute)
+	s.Warn("warn")
+	s.Close()
+	if strings.Contains(buf.String(), "suppressed") {
+		t.Errorf("summary must be logged once, got %q", buf.String())
+	}
+}
@@ -0,0 +1,82 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// ringBuffer is Sink keeping the last entries in memory.
+type ringBuffer struct {
+	mu      sync.Mutex
+	entries []logging.Entry
+	// next is index the next entry is written to.
+	next int
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{entries: make([]logging.Entry, size)}
+}
+
+// WriteEntry stores entry e overwriting the oldest one when full.
+func (r *ringBuffer) WriteEntry(e logging.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// last returns up to n last entries, oldest first.
+// All entries are returned when n <= 0.
+func (r *ringBuffer) last(n int) []logging.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size := r.next
+	if r.full {
+		size = len(r.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+	result := make([]logging.Entry, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		result = append(result, r.entries[(i+len(r.entries))%len(r.entries)])
+	}
+	return result
+}
+
+// Recent returns up to n last entries logged with s and loggers derived
+// from it, oldest first, or all kept entries when n <= 0. Entries are
+// only kept with WithRecentEntries, Recent returns nil otherwise.
+func (s *Stackdriver) Recent(n int) []logging.Entry {
+	if s.recent == nil {
+		return nil
+	}
+	return s.recent.last(n)
+}
+
+// RecentHandler returns http.Handler responding with entries returned
+// by Recent as JSON array of Cloud Logging JSON objects, e.g. to serve
+// on /debug/logs. Number of entries is limited with "n" query parameter.
+func (s *Stackdriver) RecentHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		entries := s.Recent(n)
+		result := make([]map[string]interface{}, len(entries))
+		for i, e := range entries {
+			result[i] = entryJSON(e, e.Labels)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package gcplog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestRecent(t *testing.T) {
+	l := gcplog.New(gcplog.Labels{"app": "test"},
+		gcplog.WithGCP(false),
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithRecentEntries(2),
+	)
+	if got := l.Recent(0); len(got) != 0 {
+		t.Errorf("expected no entries, got %v", got)
+	}
+	l.Info("first")
+	l.Info("second")
+	l.Warn("third")
+
+	got := l.Recent(0)
+	if len(got) != 2 || got[0].Payload.(map[string]interface{})["message"] != "second" || got[1].Labels["app"] != "test" {
+		t.Errorf("unexpected entries %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	l.RecentHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/logs?n=1", nil))
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("unmarshal %q failed: %s", rec.Body.String(), err)
+	}
+	if len(entries) != 1 || entries[0]["message"] != "third" || entries[0]["severity"] != "WARNING" {
+		t.Errorf("unexpected response %v", entries)
+	}
+}
@@ -0,0 +1,60 @@
+package gcplog
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces values of sensitive keys.
+const Redacted = "[REDACTED]"
+
+// redactor replaces values of sensitive keys in payloads.
+// It's immutable after construction.
+type redactor struct {
+	keys     map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func (r *redactor) sensitive(key string) bool {
+	if r.keys[strings.ToLower(key)] {
+		return true
+	}
+	for _, p := range r.patterns {
+		if p.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns copy of payload with values of sensitive keys
+// replaced with Redacted. Nested maps are redacted too.
+func (r *redactor) redact(payload map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		switch {
+		case r.sensitive(k):
+			result[k] = Redacted
+		default:
+			result[k] = r.redactValue(v)
+		}
+	}
+	return result
+}
+
+func (r *redactor) redactValue(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return r.redact(m)
+	case map[string]string:
+		result := make(map[string]string, len(m))
+		for k, v := range m {
+			if r.sensitive(k) {
+				v = Redacted
+			}
+			result[k] = v
+		}
+		return result
+	}
+	return v
+}
@@ -0,0 +1,35 @@
+package gcplog
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	cfg := newConfig(nil, []Option{
+		WithRedactedKeys("Password"),
+		WithRedactedKeyPatterns(regexp.MustCompile(`(?i)token$`)),
+	})
+	nested := map[string]interface{}{"PASSWORD": "secret", "user": "bob"}
+	got := cfg.redactor.redact(map[string]interface{}{
+		"message":      "login",
+		"password":     "secret",
+		"access_token": "t",
+		"nested":       nested,
+		"headers":      map[string]string{"authToken": "t", "accept": "*/*"},
+	})
+	want := map[string]interface{}{
+		"message":      "login",
+		"password":     Redacted,
+		"access_token": Redacted,
+		"nested":       map[string]interface{}{"PASSWORD": Redacted, "user": "bob"},
+		"headers":      map[string]string{"authToken": Redacted, "accept": "*/*"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redact = %v, want %v", got, want)
+	}
+	if nested["PASSWORD"] != "secret" {
+		t.Error("nested map of caller must not be modified")
+	}
+}
@@ -0,0 +1,20 @@
+package gcplog
+
+// ReplaceFunc transforms field of payload before it's emitted, e.g. to
+// rename, mask or convert it. Field is dropped when returned key is empty.
+type ReplaceFunc func(key string, value interface{}) (string, interface{})
+
+// replaceFields returns copy of payload with f applied to every field.
+// Nested maps are replaced too.
+func replaceFields(f ReplaceFunc, payload map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = replaceFields(f, m)
+		}
+		if k, v = f(k, v); k != "" {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,39 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestWithReplaceFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithRedactedKeys("secret"),
+		gcplog.WithReplaceFields(func(key string, value interface{}) (string, interface{}) {
+			switch key {
+			case "debug":
+				return "", nil
+			case "pwd":
+				return "secret", value
+			case "email":
+				return key, strings.Repeat("*", len(value.(string)))
+			}
+			return key, value
+		}))
+	l.Info("login", "debug", true, "pwd", "p", "user", map[string]interface{}{"email": "a@b"})
+	got := structuredLine(t, buf.Bytes())
+	want := map[string]interface{}{
+		"message":  "login",
+		"severity": "INFO",
+		"secret":   gcplog.Redacted,
+		"user":     map[string]interface{}{"email": "***"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry = %v, want %v", got, want)
+	}
+}
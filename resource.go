@@ -0,0 +1,119 @@
+package gcplog
+
+import (
+	"fmt"
+	"os"
+
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// ProjectResource returns "project" monitored resource,
+// the default resource entries are logged with.
+func ProjectResource(projectID string) *mrpb.MonitoredResource {
+	return &mrpb.MonitoredResource{
+		Type:   "project",
+		Labels: map[string]string{"project_id": projectID},
+	}
+}
+
+// DetectResource returns monitored resource of the environment
+// process runs in based on env variables set by Cloud Run and
+// Cloud Functions. ProjectResource is returned otherwise.
+func DetectResource(projectID string) *mrpb.MonitoredResource {
+	if name := os.Getenv("FUNCTION_TARGET"); name != "" {
+		return &mrpb.MonitoredResource{
+			Type: "cloud_function",
+			Labels: map[string]string{
+				"project_id":    projectID,
+				"function_name": name,
+				"region":        os.Getenv("FUNCTION_REGION"),
+			},
+		}
+	}
+	if service := os.Getenv("K_SERVICE"); service != "" {
+		return &mrpb.MonitoredResource{
+			Type: "cloud_run_revision",
+			Labels: map[string]string{
+				"project_id":         projectID,
+				"service_name":       service,
+				"revision_name":      os.Getenv("K_REVISION"),
+				"configuration_name": os.Getenv("K_CONFIGURATION"),
+			},
+		}
+	}
+	return ProjectResource(projectID)
+}
+
+// GKEResource returns "k8s_container" monitored resource of container
+// in pod of GKE cluster. It panics when any argument is empty.
+// Project and cluster location are filled in by New from the project
+// logs are sent for and the metadata server.
+func GKEResource(cluster, namespace, pod, container string) *mrpb.MonitoredResource {
+	requireLabels("GKEResource", "cluster", cluster, "namespace", namespace, "pod", pod, "container", container)
+	return &mrpb.MonitoredResource{
+		Type: "k8s_container",
+		Labels: map[string]string{
+			"cluster_name":   cluster,
+			"namespace_name": namespace,
+			"pod_name":       pod,
+			"container_name": container,
+		},
+	}
+}
+
+// CloudRunResource returns "cloud_run_revision" monitored resource of
+// revision of Cloud Run service deployed to location, e.g. "us-central1".
+// It panics when any argument is empty. Project is filled in by New.
+func CloudRunResource(service, revision, location string) *mrpb.MonitoredResource {
+	requireLabels("CloudRunResource", "service", service, "revision", revision, "location", location)
+	return &mrpb.MonitoredResource{
+		Type: "cloud_run_revision",
+		Labels: map[string]string{
+			"service_name":  service,
+			"revision_name": revision,
+			"location":      location,
+		},
+	}
+}
+
+// GCEResource returns "gce_instance" monitored resource of Compute
+// Engine instance in zone, e.g. "us-central1-a". It panics when any
+// argument is empty. Project is filled in by New.
+func GCEResource(instanceID, zone string) *mrpb.MonitoredResource {
+	requireLabels("GCEResource", "instanceID", instanceID, "zone", zone)
+	return &mrpb.MonitoredResource{
+		Type: "gce_instance",
+		Labels: map[string]string{
+			"instance_id": instanceID,
+			"zone":        zone,
+		},
+	}
+}
+
+// requireLabels panics when any value of name, value pairs is empty.
+func requireLabels(fn string, pairs ...string) {
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			panic(fmt.Sprintf("gcplog: %s: %s is required", fn, pairs[i]))
+		}
+	}
+}
+
+// completeResource returns copy of r with missing project_id label
+// set to projectID and, for GKE containers, missing location label
+// set to cluster location from metadata server.
+func completeResource(r *mrpb.MonitoredResource, projectID string) *mrpb.MonitoredResource {
+	labels := make(map[string]string, len(r.Labels)+2)
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	if labels["project_id"] == "" {
+		labels["project_id"] = projectID
+	}
+	if r.Type == "k8s_container" && labels["location"] == "" {
+		if location, err := getMetadata("instance/attributes/cluster-location"); err == nil && location != "" {
+			labels["location"] = location
+		}
+	}
+	return &mrpb.MonitoredResource{Type: r.Type, Labels: labels}
+}
@@ -0,0 +1,73 @@
+package gcplog
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDetectResource(t *testing.T) {
+	for _, k := range []string{"FUNCTION_TARGET", "K_SERVICE", "K_REVISION"} {
+		k := k
+		if v, ok := os.LookupEnv(k); ok {
+			t.Cleanup(func() { os.Setenv(k, v) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(k) })
+		}
+		os.Unsetenv(k)
+	}
+
+	if r := DetectResource("p"); r.Type != "project" || r.Labels["project_id"] != "p" {
+		t.Errorf("unexpected resource %v", r)
+	}
+
+	os.Setenv("K_SERVICE", "svc")
+	os.Setenv("K_REVISION", "svc-1")
+	r := DetectResource("p")
+	if r.Type != "cloud_run_revision" || r.Labels["service_name"] != "svc" || r.Labels["revision_name"] != "svc-1" {
+		t.Errorf("unexpected resource %v", r)
+	}
+}
+
+func TestResourceConstructors(t *testing.T) {
+	r := CloudRunResource("svc", "svc-1", "us-central1")
+	want := map[string]string{"service_name": "svc", "revision_name": "svc-1", "location": "us-central1"}
+	if r.Type != "cloud_run_revision" || !reflect.DeepEqual(r.Labels, want) {
+		t.Errorf("unexpected resource %v", r)
+	}
+
+	defer func() {
+		if got, want := fmt.Sprint(recover()), "gcplog: GCEResource: zone is required"; got != want {
+			t.Errorf("panic = %q, want %q", got, want)
+		}
+	}()
+	GCEResource("1", "")
+}
+
+func TestCompleteResource(t *testing.T) {
+	stubMetadata(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/attributes/cluster-location" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("europe-west1"))
+	})
+	r := GKEResource("c", "ns", "pod", "app")
+	got := completeResource(r, "p")
+	want := map[string]string{
+		"project_id":     "p",
+		"location":       "europe-west1",
+		"cluster_name":   "c",
+		"namespace_name": "ns",
+		"pod_name":       "pod",
+		"container_name": "app",
+	}
+	if got.Type != "k8s_container" || !reflect.DeepEqual(got.Labels, want) {
+		t.Errorf("unexpected resource %v", got)
+	}
+	if _, ok := r.Labels["project_id"]; ok {
+		t.Error("resource of caller must not be modified")
+	}
+}
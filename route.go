@@ -0,0 +1,51 @@
+package gcplog
+
+import (
+	"sort"
+
+	"cloud.google.com/go/logging"
+)
+
+// logRoute sends entries with severity at least minSev to GCP log
+// logName instead of the default one.
+type logRoute struct {
+	minSev  Severity
+	logName string
+	logger  *logging.Logger
+}
+
+// addRoute adds route keeping routes sorted by descending minSev,
+// replacing route with the same minSev.
+func addRoute(routes []logRoute, r logRoute) []logRoute {
+	i := sort.Search(len(routes), func(i int) bool { return routes[i].minSev <= r.minSev })
+	if i < len(routes) && routes[i].minSev == r.minSev {
+		routes[i] = r
+		return routes
+	}
+	routes = append(routes, logRoute{})
+	copy(routes[i+1:], routes[i:])
+	routes[i] = r
+	return routes
+}
+
+// routeLogger returns GCP logger entries of severity sev are sent to.
+func (s *Stackdriver) routeLogger(sev Severity) *logging.Logger {
+	for _, r := range s.routes {
+		if sev >= r.minSev {
+			return r.logger
+		}
+	}
+	return s.gcpLogger
+}
+
+// flushAll flushes default and routed GCP loggers,
+// returning the first error.
+func (s *Stackdriver) flushAll() error {
+	err := s.gcpLogger.Flush()
+	for _, r := range s.routes {
+		if ferr := r.logger.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package gcplog
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// samplerCounters is the number of counters entries are hashed to.
+const samplerCounters = 4096
+
+// sampler keeps first entries with the same severity and message
+// per tick and every thereafter-th entry after that.
+type sampler struct {
+	// counters are updated atomically, so they go first to be 64-bit
+	// aligned on 32-bit platforms.
+	counters   [samplerCounters]counter
+	tick       time.Duration
+	first      uint64
+	thereafter uint64
+	now        func() time.Time
+}
+
+func newSampler(tick time.Duration, first, thereafter int) *sampler {
+	return &sampler{
+		tick:       tick,
+		first:      uint64(first),
+		thereafter: uint64(thereafter),
+		now:        time.Now,
+	}
+}
+
+// sample reports whether entry with severity sev and message msg is kept.
+// Error and higher severity entries are always kept.
+func (s *sampler) sample(sev Severity, msg string) bool {
+	if sev >= logging.Error {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(msg))
+	i := (h.Sum32() ^ uint32(sev)) % samplerCounters
+	n := s.counters[i].inc(s.now(), s.tick)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// counter counts entries within a tick, it's accessed atomically.
+type counter struct {
+	resetAt int64
+	count   uint64
+}
+
+func (c *counter) inc(t time.Time, tick time.Duration) uint64 {
+	now := t.UnixNano()
+	resetAt := atomic.LoadInt64(&c.resetAt)
+	if resetAt > now {
+		return atomic.AddUint64(&c.count, 1)
+	}
+	atomic.StoreUint64(&c.count, 1)
+	if !atomic.CompareAndSwapInt64(&c.resetAt, resetAt, now+tick.Nanoseconds()) {
+		// other goroutine has reset the counter
+		return atomic.AddUint64(&c.count, 1)
+	}
+	return 1
+}
@@ -0,0 +1,49 @@
+package gcplog
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestSampler(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newSampler(time.Second, 2, 3)
+	s.now = func() time.Time { return now }
+
+	var kept []int
+	for i := 1; i <= 10; i++ {
+		if s.sample(logging.Info, "msg") {
+			kept = append(kept, i)
+		}
+	}
+	// first 2 are kept, then every 3rd one
+	if want := []int{1, 2, 5, 8}; !equalInts(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+
+	if !s.sample(logging.Info, "other") {
+		t.Error("other message must have its own counter")
+	}
+	if !s.sample(logging.Error, "msg") {
+		t.Error("errors must not be sampled")
+	}
+
+	now = now.Add(time.Second)
+	if !s.sample(logging.Info, "msg") {
+		t.Error("counter must be reset after tick")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package gcplog
+
+import (
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/logging"
+)
+
+// ErrSink is reported to error handler when sink fails to write entry.
+var ErrSink = errors.New("write to sink failed")
+
+// Sink is additional destination of log entries, e.g. a file, a queue
+// or a metrics counter. Entries are passed with payload processed and
+// labels merged with common labels.
+type Sink interface {
+	WriteEntry(e logging.Entry) error
+}
+
+// SinkFunc is adapter to use ordinary function as Sink.
+type SinkFunc func(e logging.Entry) error
+
+// WriteEntry calls f(e).
+func (f SinkFunc) WriteEntry(e logging.Entry) error { return f(e) }
+
+// writeSinks writes entry e to all sinks, failures are passed to error
+// handler and don't stop other sinks.
+func (s *Stackdriver) writeSinks(e logging.Entry) {
+	if len(s.sinks) == 0 {
+		return
+	}
+	e.Labels = s.entryLabels(e)
+	for _, sink := range s.sinks {
+		if err := sink.WriteEntry(e); err != nil && s.onError != nil {
+			s.onError(fmt.Errorf("%w: %s", ErrSink, err))
+		}
+	}
+}
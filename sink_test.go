@@ -0,0 +1,42 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestSinks(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		got   []logging.Entry
+		errs  []error
+		fails = gcplog.SinkFunc(func(logging.Entry) error { return errors.New("disk full") })
+		saves = gcplog.SinkFunc(func(e logging.Entry) error { got = append(got, e); return nil })
+	)
+	l := gcplog.New(map[string]string{"app": "test"},
+		gcplog.WithStructuredStdout(),
+		gcplog.WithWriter(&buf),
+		gcplog.WithSinks(fails, saves),
+		gcplog.WithErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+	l.With(map[string]string{"k": "v"}).Info("hello", "n", 1)
+
+	if len(got) != 1 {
+		t.Fatalf("expected entry in second sink, got %v", got)
+	}
+	if got[0].Labels["app"] != "test" || got[0].Labels["k"] != "v" || got[0].Severity != logging.Info {
+		t.Errorf("unexpected entry %+v", got[0])
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], gcplog.ErrSink) {
+		t.Errorf("expected sink error, got %v", errs)
+	}
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("entry must still be printed to stdout: %q", buf.String())
+	}
+}
@@ -0,0 +1,127 @@
+//go:build go1.21
+// +build go1.21
+
+package gcplog
+
+import (
+	"context"
+	"log/slog"
+
+	"cloud.google.com/go/logging"
+)
+
+// slogHandler is slog.Handler writing records to Stackdriver.
+type slogHandler struct {
+	sd *Stackdriver
+	// goas are attrs and groups added with WithAttrs and WithGroup
+	// in the order of calls.
+	goas []groupOrAttrs
+}
+
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewSlogHandler returns slog.Handler logging records with sd.
+// Record attributes become payload fields, groups become nested maps.
+func NewSlogHandler(sd *Stackdriver) slog.Handler {
+	return &slogHandler{sd: sd}
+}
+
+// SeverityForSlogLevel maps slog.Level to Severity.
+func SeverityForSlogLevel(l slog.Level) Severity {
+	switch {
+	case l < slog.LevelInfo:
+		return logging.Debug
+	case l < slog.LevelWarn:
+		return logging.Info
+	case l < slog.LevelError:
+		return logging.Warning
+	case l < slog.LevelError+4:
+		return logging.Error
+	default:
+		return logging.Critical
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return h.sd.enabled(SeverityForSlogLevel(l))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	sev := SeverityForSlogLevel(r.Level)
+	if !h.sd.sample(sev, r.Message) || !h.sd.rateLimit(sev) {
+		return nil
+	}
+	payload := map[string]interface{}{"message": r.Message}
+	goas := h.goas
+	if r.NumAttrs() == 0 {
+		// trailing groups without attrs are omitted
+		for len(goas) > 0 && goas[len(goas)-1].group != "" {
+			goas = goas[:len(goas)-1]
+		}
+	}
+	cur := payload
+	for _, goa := range goas {
+		if goa.group != "" {
+			m := map[string]interface{}{}
+			cur[goa.group] = m
+			cur = m
+			continue
+		}
+		for _, a := range goa.attrs {
+			addSlogAttr(cur, a)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addSlogAttr(cur, a)
+		return true
+	})
+	h.sd.logPayload(sev, payload)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(groupOrAttrs{attrs: attrs})
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *slogHandler) with(goa groupOrAttrs) *slogHandler {
+	goas := make([]groupOrAttrs, len(h.goas), len(h.goas)+1)
+	copy(goas, h.goas)
+	return &slogHandler{sd: h.sd, goas: append(goas, goa)}
+}
+
+// addSlogAttr adds attribute a to m, nesting group attributes.
+func addSlogAttr(m map[string]interface{}, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		m[a.Key] = a.Value.Any()
+		return
+	}
+	attrs := a.Value.Group()
+	if len(attrs) == 0 {
+		return
+	}
+	g := m
+	if a.Key != "" {
+		g = map[string]interface{}{}
+		m[a.Key] = g
+	}
+	for _, ga := range attrs {
+		addSlogAttr(g, ga)
+	}
+}
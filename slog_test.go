@@ -0,0 +1,66 @@
+//go:build go1.21
+// +build go1.21
+
+package gcplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	sd := &Stackdriver{Logger: log.New(&buf, "", 0)}
+	l := slog.New(NewSlogHandler(sd)).With("a", 1).WithGroup("g").With("b", "2")
+	l.Info("hello", slog.Group("h", "c", true), "d", 3)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal %q failed: %s", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"message": "hello",
+		"a":       1.0,
+		"g": map[string]interface{}{
+			"b": "2",
+			"d": 3.0,
+			"h": map[string]interface{}{"c": true},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("payload = %v, want %v", got, want)
+	}
+}
+
+func TestSlogHandlerEnabled(t *testing.T) {
+	sd := &Stackdriver{minLevel: int32(logging.Warning)}
+	h := NewSlogHandler(sd)
+	if h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info must be disabled")
+	}
+	if !h.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("warn must be enabled")
+	}
+}
+
+func TestSeverityForSlogLevel(t *testing.T) {
+	tests := map[slog.Level]Severity{
+		slog.LevelDebug:     logging.Debug,
+		slog.LevelInfo:      logging.Info,
+		slog.LevelWarn:      logging.Warning,
+		slog.LevelError:     logging.Error,
+		slog.LevelError + 4: logging.Critical,
+	}
+	for l, want := range tests {
+		if got := SeverityForSlogLevel(l); got != want {
+			t.Errorf("severity for %s = %v, want %v", l, got, want)
+		}
+	}
+}
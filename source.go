@@ -0,0 +1,64 @@
+package gcplog
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+// pkgDir is the directory of this package's source files.
+// Frames from it are skipped, so reported location is the user's
+// call site regardless of how many wrappers were involved.
+var pkgDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callerLocation returns location of the first caller outside of this package.
+func callerLocation() *logpb.LogEntrySourceLocation {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if external(f) {
+			return &logpb.LogEntrySourceLocation{
+				File:     f.File,
+				Line:     int64(f.Line),
+				Function: f.Function,
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// callerDepth returns calldepth for log.Logger.Output called by
+// the caller of callerDepth, so that the first caller outside of this
+// package is reported with log.Lshortfile or log.Llongfile.
+func callerDepth() int {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	// depth 1 is the caller of Output, first frame is its caller
+	for depth := 2; ; depth++ {
+		f, more := frames.Next()
+		if external(f) {
+			return depth
+		}
+		if !more {
+			return 1
+		}
+	}
+}
+
+// external reports whether frame f is outside of this package and its
+// adapter subpackages like zapgcp.
+func external(f runtime.Frame) bool {
+	dir := filepath.Dir(f.File)
+	internal := dir == pkgDir || strings.HasPrefix(dir, pkgDir+"/")
+	return !internal || strings.HasSuffix(f.File, "_test.go")
+}
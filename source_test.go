@@ -0,0 +1,19 @@
+package gcplog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallerLocation(t *testing.T) {
+	loc := callerLocation()
+	if loc == nil {
+		t.Fatal("location is nil")
+	}
+	if !strings.HasSuffix(loc.File, "source_test.go") || loc.Line != 9 {
+		t.Errorf("location = %s:%d", loc.File, loc.Line)
+	}
+	if !strings.HasSuffix(loc.Function, "TestCallerLocation") {
+		t.Errorf("function = %s", loc.Function)
+	}
+}
@@ -0,0 +1,60 @@
+package gcplog
+
+import (
+	"sync/atomic"
+
+	"cloud.google.com/go/logging"
+)
+
+// Stats holds counters of logger's entries since its creation.
+type Stats struct {
+	// Emitted is number of entries written per severity: printed,
+	// written to sinks or sent to GCP. Entries filtered out by
+	// WithStdoutLevel and WithGCPLevel everywhere, or dropped by
+	// WithDropInvalidPayloads, aren't counted.
+	Emitted map[Severity]uint64
+	// Errors is number of failures reported to error handler,
+	// e.g. entries GCP client failed to send.
+	Errors uint64
+	// Sampled is number of entries dropped by sampling.
+	Sampled uint64
+	// RateLimited is number of entries dropped by rate limit.
+	RateLimited uint64
+}
+
+// counters are shared by logger and loggers derived from it,
+// all fields are accessed atomically.
+type counters struct {
+	emitted     [logging.Emergency/100 + 1]uint64
+	errors      uint64
+	sampled     uint64
+	rateLimited uint64
+}
+
+func (c *counters) emit(sev Severity) {
+	if c == nil {
+		return
+	}
+	if i := int(sev) / 100; i >= 0 && i < len(c.emitted) {
+		atomic.AddUint64(&c.emitted[i], 1)
+	}
+}
+
+// Stats returns counters of entries emitted and dropped by s and loggers
+// derived from it.
+func (s *Stackdriver) Stats() Stats {
+	st := Stats{Emitted: map[Severity]uint64{}}
+	c := s.counters
+	if c == nil {
+		return st
+	}
+	for i := range c.emitted {
+		if n := atomic.LoadUint64(&c.emitted[i]); n > 0 {
+			st.Emitted[Severity(i*100)] = n
+		}
+	}
+	st.Errors = atomic.LoadUint64(&c.errors)
+	st.Sampled = atomic.LoadUint64(&c.sampled)
+	st.RateLimited = atomic.LoadUint64(&c.rateLimited)
+	return st
+}
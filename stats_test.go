@@ -0,0 +1,63 @@
+package gcplog_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestStats(t *testing.T) {
+	failing := gcplog.SinkFunc(func(logging.Entry) error { return errors.New("boom") })
+	l := gcplog.New(nil,
+		gcplog.WithStructuredStdout(),
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithGCP(false),
+		gcplog.WithSampling(1, 0),
+		gcplog.WithRateLimit(logging.Warning, 1, time.Hour),
+		gcplog.WithSinks(failing),
+		gcplog.WithErrorHandler(func(error) {}),
+	)
+	l.Info("a")
+	l.Info("a")
+	l.With(map[string]string{"k": "v"}).Warn("b")
+	l.Warn("c")
+
+	st := l.Stats()
+	if st.Emitted[logging.Info] != 1 || st.Emitted[logging.Warning] != 1 {
+		t.Errorf("unexpected emitted %v", st.Emitted)
+	}
+	if st.Sampled != 1 || st.RateLimited != 1 || st.Errors != 2 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestStatsCountWrittenEntries(t *testing.T) {
+	l := gcplog.New(nil,
+		gcplog.WithStructuredStdout(),
+		gcplog.WithWriter(ioutil.Discard),
+		gcplog.WithGCP(false),
+		gcplog.WithStdoutLevel(logging.Info),
+		gcplog.WithPayloadValidator(func(p map[string]interface{}) error {
+			if p["bad"] != nil {
+				return errors.New("bad")
+			}
+			return nil
+		}),
+		gcplog.WithDropInvalidPayloads(),
+	)
+	l.Debug("filtered")
+	l.Info("dropped", "bad", true)
+	l.Info("written")
+
+	// the validation warning is written too
+	st := l.Stats()
+	if want := map[gcplog.Severity]uint64{logging.Info: 1, logging.Warning: 1}; !reflect.DeepEqual(st.Emitted, want) {
+		t.Errorf("emitted = %v, want %v", st.Emitted, want)
+	}
+}
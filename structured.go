@@ -0,0 +1,167 @@
+package gcplog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Special fields recognized by logging agent in JSON lines, see
+// https://cloud.google.com/logging/docs/structured-logging
+const (
+	fieldSeverity = "severity"
+	fieldMessage  = "message"
+	fieldLabels   = "logging.googleapis.com/labels"
+	fieldTrace    = "logging.googleapis.com/trace"
+	fieldSpanID   = "logging.googleapis.com/spanId"
+	fieldOp       = "logging.googleapis.com/operation"
+
+	fieldTimestamp      = "timestamp"
+	fieldSourceLocation = "logging.googleapis.com/sourceLocation"
+	fieldTraceSampled   = "logging.googleapis.com/trace_sampled"
+	fieldInsertID       = "logging.googleapis.com/insertId"
+	fieldHTTPRequest    = "httpRequest"
+)
+
+// structuredEntry returns entry e as Cloud Logging JSON object.
+// Structured payload fields are put at top level.
+func (s *Stackdriver) structuredEntry(e logging.Entry) map[string]interface{} {
+	return entryJSON(e, s.entryLabels(e))
+}
+
+// entryJSON returns entry e with labels as Cloud Logging JSON object.
+func entryJSON(e logging.Entry, labels Labels) map[string]interface{} {
+	m := map[string]interface{}{}
+	switch p := e.Payload.(type) {
+	case map[string]interface{}:
+		for k, v := range p {
+			m[k] = v
+		}
+	case *protoPayload:
+		for k, v := range p.st.AsMap() {
+			m[k] = v
+		}
+	case *structpb.Struct:
+		// proto payload as passed to sinks
+		for k, v := range p.AsMap() {
+			m[k] = v
+		}
+	default:
+		m[fieldMessage] = p
+	}
+	m[fieldSeverity] = strings.ToUpper(e.Severity.String())
+	if len(labels) > 0 {
+		m[fieldLabels] = labels
+	}
+	if e.Trace != "" {
+		m[fieldTrace] = e.Trace
+	}
+	if e.SpanID != "" {
+		m[fieldSpanID] = e.SpanID
+	}
+	if e.TraceSampled {
+		m[fieldTraceSampled] = true
+	}
+	if e.InsertID != "" {
+		m[fieldInsertID] = e.InsertID
+	}
+	if !e.Timestamp.IsZero() {
+		m[fieldTimestamp] = e.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if loc := e.SourceLocation; loc != nil {
+		m[fieldSourceLocation] = map[string]interface{}{
+			"file":     loc.File,
+			"line":     strconv.FormatInt(loc.Line, 10),
+			"function": loc.Function,
+		}
+	}
+	if e.HTTPRequest != nil {
+		m[fieldHTTPRequest] = httpRequestJSON(e.HTTPRequest)
+	}
+	if op := e.Operation; op != nil {
+		m[fieldOp] = map[string]interface{}{
+			"id":       op.Id,
+			"producer": op.Producer,
+			"first":    op.First,
+			"last":     op.Last,
+		}
+	}
+	return m
+}
+
+// httpRequestJSON returns hr as HttpRequest JSON object the logging
+// agent recognizes. Zero fields are omitted.
+func httpRequestJSON(hr *logging.HTTPRequest) map[string]interface{} {
+	m := map[string]interface{}{}
+	if r := hr.Request; r != nil {
+		m["requestMethod"] = r.Method
+		if r.URL != nil {
+			m["requestUrl"] = r.URL.String()
+		}
+		if ua := r.UserAgent(); ua != "" {
+			m["userAgent"] = ua
+		}
+		if ref := r.Referer(); ref != "" {
+			m["referer"] = ref
+		}
+		m["protocol"] = r.Proto
+	}
+	if hr.RequestSize > 0 {
+		m["requestSize"] = strconv.FormatInt(hr.RequestSize, 10)
+	}
+	if hr.Status != 0 {
+		m["status"] = hr.Status
+	}
+	if hr.ResponseSize > 0 {
+		m["responseSize"] = strconv.FormatInt(hr.ResponseSize, 10)
+	}
+	if hr.Latency > 0 {
+		m["latency"] = fmt.Sprintf("%.9fs", hr.Latency.Seconds())
+	}
+	if hr.RemoteIP != "" {
+		m["remoteIp"] = hr.RemoteIP
+	}
+	if hr.LocalIP != "" {
+		m["serverIp"] = hr.LocalIP
+	}
+	if hr.CacheHit {
+		m["cacheHit"] = true
+	}
+	if hr.CacheLookup {
+		m["cacheLookup"] = true
+	}
+	if hr.CacheValidatedWithOriginServer {
+		m["cacheValidatedWithOriginServer"] = true
+	}
+	if hr.CacheFillBytes > 0 {
+		m["cacheFillBytes"] = strconv.FormatInt(hr.CacheFillBytes, 10)
+	}
+	return m
+}
+
+// entryLabels returns common labels merged with labels of entry e,
+// as GCP would store them.
+func (s *Stackdriver) entryLabels(e logging.Entry) Labels {
+	labels := make(Labels, len(s.commonLabels)+len(e.Labels))
+	for k, v := range s.commonLabels {
+		labels[k] = v
+	}
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// printStructured writes entry e as single JSON line.
+func (s *Stackdriver) printStructured(e logging.Entry) {
+	text, err := marshalJSON(s.structuredEntry(e))
+	if err != nil {
+		s.jsonLogger.Printf(`{"severity":"ERROR","message":%q}`, "failed to marshal: "+err.Error())
+		return
+	}
+	s.jsonLogger.Print(text)
+}
@@ -0,0 +1,219 @@
+package gcplog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// TestEntry is an entry recorded by TestLogger.
+type TestEntry struct {
+	Severity    Severity
+	Message     string
+	Labels      Labels
+	Fields      map[string]interface{}
+	HTTPRequest *logging.HTTPRequest
+	Trace       string
+	SpanID      string
+}
+
+// TestLogger records entries logged with ExtendedLogger
+// returned by NewTestLogger, so tests can assert on them.
+type TestLogger struct {
+	// ExitOnFatal makes Fatal and Crit call os.Exit(1) after recording.
+	// By default they only record the entry.
+	ExitOnFatal bool
+
+	mu      sync.Mutex
+	entries []TestEntry
+}
+
+// NewTestLogger returns recorder and ExtendedLogger writing to it.
+// It doesn't write to stderr or GCP.
+func NewTestLogger() (*TestLogger, ExtendedLogger) {
+	t := &TestLogger{}
+	return t, &testLogger{rec: t}
+}
+
+// Entries returns copy of recorded entries.
+func (t *TestLogger) Entries() []TestEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TestEntry(nil), t.entries...)
+}
+
+// Reset removes recorded entries.
+func (t *TestLogger) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = nil
+}
+
+func (t *TestLogger) record(e TestEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, e)
+}
+
+func (t *TestLogger) exit() {
+	if t.ExitOnFatal {
+		os.Exit(1)
+	}
+}
+
+// testLogger is ExtendedLogger recording entries to rec.
+type testLogger struct {
+	rec    *TestLogger
+	labels Labels
+	req    *logging.HTTPRequest
+	ctx    context.Context
+	trace  string
+	spanID string
+	err    error
+	fields map[string]interface{}
+	group  []string
+	name   string
+}
+
+func (l *testLogger) clone() *testLogger {
+	c := *l
+	return &c
+}
+
+func (l *testLogger) WithRequest(req *logging.HTTPRequest) ExtendedLogger {
+	c := l.clone()
+	c.req = req
+	return c
+}
+
+func (l *testLogger) WithContext(ctx context.Context) ExtendedLogger {
+	c := l.clone()
+	c.ctx = ctx
+	return c
+}
+
+func (l *testLogger) WithTrace(traceID, spanID string) ExtendedLogger {
+	c := l.clone()
+	c.trace = traceID
+	c.spanID = spanID
+	return c
+}
+
+func (l *testLogger) WithError(err error) ExtendedLogger {
+	c := l.clone()
+	c.err = err
+	return c
+}
+
+func (l *testLogger) WithFields(fields map[string]interface{}) ExtendedLogger {
+	c := l.clone()
+	c.fields = make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+	g := groupMap(c.fields, l.group)
+	for k, v := range fields {
+		g[k] = v
+	}
+	return c
+}
+
+func (l *testLogger) Group(name string) ExtendedLogger {
+	if name == "" {
+		return l
+	}
+	c := l.clone()
+	c.group = append(l.group[:len(l.group):len(l.group)], name)
+	return c
+}
+
+func (l *testLogger) Named(name string) ExtendedLogger {
+	if name == "" {
+		return l
+	}
+	n := name
+	if l.name != "" {
+		n = l.name + "." + name
+	}
+	c := l.With(Labels{loggerLabel: n}).(*testLogger)
+	c.name = n
+	return c
+}
+
+func (l *testLogger) With(labels map[string]string) ExtendedLogger {
+	c := l.clone()
+	c.labels = make(Labels, len(l.labels)+len(labels))
+	for k, v := range l.labels {
+		c.labels[k] = v
+	}
+	for k, v := range labels {
+		c.labels[k] = v
+	}
+	return c
+}
+
+func (l *testLogger) record(sev Severity, msg string, fields map[string]interface{}) {
+	l.rec.record(TestEntry{
+		Severity:    sev,
+		Message:     msg,
+		Labels:      l.labels,
+		Fields:      fields,
+		HTTPRequest: l.req,
+		Trace:       l.trace,
+		SpanID:      l.spanID,
+	})
+}
+
+func (l *testLogger) Print(args ...interface{})   { l.Printf(fmt.Sprint(args...)) }
+func (l *testLogger) Println(args ...interface{}) { l.Printf(fmt.Sprintln(args...)) }
+
+func (l *testLogger) Printf(msg string, args ...interface{}) {
+	l.record(logging.Info, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *testLogger) Fatal(args ...interface{})   { l.Fatalf(fmt.Sprint(args...)) }
+func (l *testLogger) Fatalln(args ...interface{}) { l.Fatalf(fmt.Sprintln(args...)) }
+
+func (l *testLogger) Fatalf(msg string, args ...interface{}) {
+	l.record(logging.Critical, fmt.Sprintf(msg, args...), nil)
+	l.rec.exit()
+}
+
+func (l *testLogger) Panic(args ...interface{})   { l.Panicf(fmt.Sprint(args...)) }
+func (l *testLogger) Panicln(args ...interface{}) { l.Panicf(fmt.Sprintln(args...)) }
+
+func (l *testLogger) Panicf(msg string, args ...interface{}) {
+	l.record(logging.Critical, fmt.Sprintf(msg, args...), nil)
+	panic(fmt.Sprintf(msg, args...))
+}
+
+func (l *testLogger) Log(sev Severity, msg string, args ...interface{}) {
+	fields := formatPayload(msg, args...)
+	delete(fields, "message")
+	if len(l.group) > 0 {
+		fields = groupPayload(fields, l.group)
+	}
+	mergeFields(fields, l.fields)
+	if l.err != nil {
+		for k, v := range errorFields(l.err) {
+			fields[k] = v
+		}
+	}
+	l.record(sev, msg, fields)
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.Log(logging.Debug, msg, args...) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.Log(logging.Info, msg, args...) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.Log(logging.Warning, msg, args...) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.Log(logging.Error, msg, args...) }
+
+func (l *testLogger) Crit(msg string, args ...interface{}) {
+	l.Log(logging.Critical, msg, args...)
+	l.rec.exit()
+}
+
+func (l *testLogger) Flush() error { return nil }
+func (l *testLogger) Close() error { return nil }
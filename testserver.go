@@ -0,0 +1,132 @@
+package gcplog
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"google.golang.org/api/option"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestServer is in-memory fake of GCP logging API recording entries
+// written to it, so tests can assert on what is sent to GCP.
+type TestServer struct {
+	*logpb.UnimplementedLoggingServiceV2Server
+
+	srv  *grpc.Server
+	conn *grpc.ClientConn
+
+	mu      sync.Mutex
+	entries []*logpb.LogEntry
+	err     error
+	block   chan struct{}
+}
+
+// NewTestServer starts TestServer on in-memory connection and returns
+// it with client options pointing GCP client to it:
+//
+//	srv, opts := gcplog.NewTestServer()
+//	defer srv.Close()
+//	l, err := gcplog.NewWithError(cl,
+//		gcplog.WithProjectID("test-project"),
+//		gcplog.WithClientOptions(opts...),
+//	)
+func NewTestServer() (*TestServer, []option.ClientOption) {
+	lis := bufconn.Listen(1 << 20)
+	ts := &TestServer{
+		UnimplementedLoggingServiceV2Server: &logpb.UnimplementedLoggingServiceV2Server{},
+		srv:                                 grpc.NewServer(),
+	}
+	logpb.RegisterLoggingServiceV2Server(ts.srv, ts)
+	go ts.srv.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		// dialing is non-blocking, so it only fails on bad options
+		panic(err)
+	}
+	ts.conn = conn
+	return ts, []option.ClientOption{option.WithGRPCConn(conn), option.WithoutAuthentication()}
+}
+
+// WriteLogEntries records entries of req with its log name and labels
+// applied, as GCP would store them.
+func (ts *TestServer) WriteLogEntries(_ context.Context, req *logpb.WriteLogEntriesRequest) (*logpb.WriteLogEntriesResponse, error) {
+	ts.mu.Lock()
+	block := ts.block
+	ts.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.err != nil {
+		return nil, ts.err
+	}
+	for _, e := range req.Entries {
+		if e.LogName == "" {
+			e.LogName = req.LogName
+		}
+		if e.Labels == nil {
+			e.Labels = map[string]string{}
+		}
+		for k, v := range req.Labels {
+			if _, ok := e.Labels[k]; !ok {
+				e.Labels[k] = v
+			}
+		}
+		ts.entries = append(ts.entries, e)
+	}
+	return &logpb.WriteLogEntriesResponse{}, nil
+}
+
+// Entries returns copy of recorded entries.
+func (ts *TestServer) Entries() []*logpb.LogEntry {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]*logpb.LogEntry(nil), ts.entries...)
+}
+
+// Reset removes recorded entries.
+func (ts *TestServer) Reset() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.entries = nil
+}
+
+// SetError makes writes fail with err, nil restores success.
+func (ts *TestServer) SetError(err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.err = err
+}
+
+// Block makes writes wait until returned release function is called,
+// e.g. to test flush timeouts.
+func (ts *TestServer) Block() (release func()) {
+	ch := make(chan struct{})
+	ts.mu.Lock()
+	ts.block = ch
+	ts.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ts.mu.Lock()
+			ts.block = nil
+			ts.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Close stops the server.
+func (ts *TestServer) Close() {
+	ts.conn.Close()
+	ts.srv.Stop()
+}
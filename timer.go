@@ -0,0 +1,15 @@
+package gcplog
+
+import "time"
+
+// Timer logs msg + " started" at Debug and returns function logging
+// msg + " finished" at Info with duration_ms field, e.g.
+//
+//	defer sd.Timer("db query")()
+func (s *Stackdriver) Timer(msg string) func() {
+	s.Debug(msg + " started")
+	start := time.Now()
+	return func() {
+		s.Info(msg+" finished", "duration_ms", time.Since(start).Milliseconds())
+	}
+}
@@ -0,0 +1,96 @@
+package gcplog
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HeaderCloudTraceContext is the header GCP load balancers
+// use to propagate trace in TRACE_ID/SPAN_ID;o=TRACE_TRUE format.
+const HeaderCloudTraceContext = "X-Cloud-Trace-Context"
+
+// HeaderTraceparent is W3C Trace Context header
+// in 00-TRACE_ID-SPAN_ID-FLAGS format used by OpenTelemetry.
+const HeaderTraceparent = "traceparent"
+
+// WithTrace returns logger attaching Cloud Trace traceID and spanID to entries,
+// so they are grouped with the trace in GCP console.
+// Trace ID is qualified as projects/PROJECT/traces/TRACE_ID unless it already is.
+func (s *Stackdriver) WithTrace(traceID, spanID string) ExtendedLogger {
+	c := s.clone()
+	c.trace = c.qualifyTrace(traceID)
+	c.spanID = spanID
+	c.traceSampled = false
+	return c
+}
+
+// qualifyTrace returns traceID in projects/PROJECT/traces/TRACE_ID format.
+func (s *Stackdriver) qualifyTrace(traceID string) string {
+	if traceID == "" || strings.HasPrefix(traceID, "projects/") || s.projectID == "" {
+		return traceID
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", s.projectID, traceID)
+}
+
+// parseCloudTraceContext parses X-Cloud-Trace-Context header value.
+// Decimal span ID is converted to 16-digit hex form used by Cloud Logging.
+// Trace is sampled when o=1 option is present.
+func parseCloudTraceContext(h string) (traceID, spanID string, sampled, ok bool) {
+	if i := strings.Index(h, ";"); i >= 0 {
+		for _, opt := range strings.Split(h[i+1:], ";") {
+			if strings.TrimSpace(opt) == "o=1" {
+				sampled = true
+			}
+		}
+		h = h[:i]
+	}
+	parts := strings.SplitN(h, "/", 2)
+	traceID = parts[0]
+	if traceID == "" {
+		return "", "", false, false
+	}
+	if len(parts) == 2 {
+		if n, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+			spanID = fmt.Sprintf("%016x", n)
+		}
+	}
+	return traceID, spanID, sampled, true
+}
+
+// parseTraceparent parses W3C traceparent header value.
+// Trace is sampled when sampled bit of flags is set.
+func parseTraceparent(h string) (traceID, spanID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false, false
+	}
+	traceID, spanID = parts[1], parts[2]
+	if len(traceID) != 32 || !isHex(traceID) || strings.Trim(traceID, "0") == "" {
+		return "", "", false, false
+	}
+	if len(spanID) != 16 || !isHex(spanID) || strings.Trim(spanID, "0") == "" {
+		return "", "", false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	return traceID, spanID, err == nil && flags&1 == 1, true
+}
+
+// requestTrace returns trace of request headers h preferring
+// traceparent over X-Cloud-Trace-Context.
+func requestTrace(h http.Header) (traceID, spanID string, sampled, ok bool) {
+	if traceID, spanID, sampled, ok = parseTraceparent(h.Get(HeaderTraceparent)); ok {
+		return traceID, spanID, sampled, ok
+	}
+	return parseCloudTraceContext(h.Get(HeaderCloudTraceContext))
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package gcplog
+
+import (
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		header, trace, span string
+		sampled, ok         bool
+	}{
+		{"105445aa7843bc8bf206b12000100000/1;o=1", "105445aa7843bc8bf206b12000100000", "0000000000000001", true, true},
+		{"105445aa7843bc8bf206b12000100000/1;o=0", "105445aa7843bc8bf206b12000100000", "0000000000000001", false, true},
+		{"105445aa7843bc8bf206b12000100000/255", "105445aa7843bc8bf206b12000100000", "00000000000000ff", false, true},
+		{"105445aa7843bc8bf206b12000100000", "105445aa7843bc8bf206b12000100000", "", false, true},
+		{"", "", "", false, false},
+	}
+	for _, tt := range tests {
+		trace, span, sampled, ok := parseCloudTraceContext(tt.header)
+		if trace != tt.trace || span != tt.span || sampled != tt.sampled || ok != tt.ok {
+			t.Errorf("parse(%q) = %q, %q, %v, %v; want %q, %q, %v, %v", tt.header, trace, span, sampled, ok, tt.trace, tt.span, tt.sampled, tt.ok)
+		}
+	}
+}
+
+func TestWithRequestExtractsTrace(t *testing.T) {
+	s := &Stackdriver{projectID: "p"}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderCloudTraceContext, "abc/10;o=1")
+	l := s.WithRequest(&logging.HTTPRequest{Request: r}).(*Stackdriver)
+	if l.trace != "projects/p/traces/abc" || l.spanID != "000000000000000a" || !l.traceSampled {
+		t.Errorf("trace = %q, span = %q, sampled = %v", l.trace, l.spanID, l.traceSampled)
+	}
+}
+
+func TestWithTraceQualifiesTraceID(t *testing.T) {
+	s := &Stackdriver{projectID: "p"}
+	if got := s.WithTrace("abc", "1").(*Stackdriver).trace; got != "projects/p/traces/abc" {
+		t.Errorf("trace = %q", got)
+	}
+	if got := s.WithTrace("projects/q/traces/abc", "1").(*Stackdriver).trace; got != "projects/q/traces/abc" {
+		t.Errorf("trace = %q", got)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header, trace, span string
+		sampled, ok         bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false, true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", "", false, false},
+		{"00-4bf92f-00f067aa0ba902b7-01", "", "", false, false},
+		{"", "", "", false, false},
+	}
+	for _, tt := range tests {
+		trace, span, sampled, ok := parseTraceparent(tt.header)
+		if trace != tt.trace || span != tt.span || sampled != tt.sampled || ok != tt.ok {
+			t.Errorf("parse(%q) = %q, %q, %v, %v; want %q, %q, %v, %v", tt.header, trace, span, sampled, ok, tt.trace, tt.span, tt.sampled, tt.ok)
+		}
+	}
+}
+
+func TestWithRequestPrefersTraceparent(t *testing.T) {
+	s := &Stackdriver{projectID: "p"}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderCloudTraceContext, "abc/10;o=1")
+	r.Header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	l := s.WithRequest(&logging.HTTPRequest{Request: r}).(*Stackdriver)
+	if l.trace != "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736" || l.spanID != "00f067aa0ba902b7" {
+		t.Errorf("trace = %q, span = %q", l.trace, l.spanID)
+	}
+}
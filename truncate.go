@@ -0,0 +1,106 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DefaultMaxPayloadSize is the default limit of entry payload size.
+// It's a bit below 256KB GCP accepts per entry, leaving room for labels
+// and other entry fields.
+const DefaultMaxPayloadSize = 250 << 10
+
+// truncatedKey marks payloads replaced because of their size.
+const truncatedKey = "truncated"
+
+// truncatePayload returns payload p, or summary of it when its JSON
+// encoding is longer than max bytes. Summary keeps the message and the
+// beginning of encoded payload, shortened so that summary fits max.
+func truncatePayload(p interface{}, max int) interface{} {
+	var (
+		b   []byte
+		msg string
+	)
+	switch v := p.(type) {
+	case string:
+		if len(v) <= max {
+			return p
+		}
+		return map[string]interface{}{
+			"message":    truncateUTF8(v, max/2),
+			truncatedKey: true,
+		}
+	case *protoPayload:
+		b = v.json
+	case map[string]interface{}:
+		msg, _ = v["message"].(string)
+		if n, ok := sizeBound(v); ok && n <= max {
+			// most payloads are small, encoding them twice is avoided
+			return p
+		}
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			// printing reports the error
+			return p
+		}
+	default:
+		return p
+	}
+	if len(b) <= max {
+		return p
+	}
+	// escaping of kept JSON may double its size
+	n := max / 4
+	return map[string]interface{}{
+		"message":    truncateUTF8(msg, n),
+		truncatedKey: true,
+		"size":       len(b),
+		"payload":    truncateUTF8(string(b), n),
+	}
+}
+
+// sizeBound returns upper bound of length of JSON encoding of v, false
+// when v is of type the bound isn't known for. Strings are assumed to
+// be escaped entirely.
+func sizeBound(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case nil, bool:
+		return len("false"), true
+	case string:
+		return len(`""`) + len(v)*len(`\u0000`), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		// e.g. -1.7976931348623157e+308
+		return 24, true
+	case time.Time:
+		return len(`"` + time.RFC3339Nano + `"`), true
+	case map[string]interface{}:
+		n := len("{}")
+		for k, e := range v {
+			m, ok := sizeBound(e)
+			if !ok {
+				return 0, false
+			}
+			k, _ := sizeBound(k)
+			n += k + len(":,") + m
+		}
+		return n, true
+	case []interface{}:
+		n := len("[]")
+		for _, e := range v {
+			m, ok := sizeBound(e)
+			if !ok {
+				return 0, false
+			}
+			n += m + len(",")
+		}
+		return n, true
+	case []string:
+		n := len("[]")
+		for _, e := range v {
+			m, _ := sizeBound(e)
+			n += m + len(",")
+		}
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package gcplog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruncatePayload(t *testing.T) {
+	small := map[string]interface{}{"message": "hello"}
+	if got := truncatePayload(small, 100); got == nil || len(got.(map[string]interface{})) != 1 {
+		t.Errorf("small payload must be kept, got %v", got)
+	}
+
+	big := map[string]interface{}{"message": "hello", "data": strings.Repeat(`"ж`, 100)}
+	got, ok := truncatePayload(big, 100).(map[string]interface{})
+	if !ok || got[truncatedKey] != true || got["message"] != "hello" {
+		t.Fatalf("unexpected summary %v", got)
+	}
+	b, _ := json.Marshal(got)
+	if len(b) > 150 {
+		t.Errorf("summary %s is too long", b)
+	}
+
+	text, ok := truncatePayload(strings.Repeat("a", 300), 100).(map[string]interface{})
+	if !ok || text[truncatedKey] != true || text["message"] != strings.Repeat("a", 50) {
+		t.Errorf("unexpected text summary %v", text)
+	}
+}
+
+func TestSizeBound(t *testing.T) {
+	for _, v := range []interface{}{
+		map[string]interface{}{
+			"message": "hello <\u2028>\x01",
+			"n":       -1.7976931348623157e+308,
+			"ok":      false,
+			"none":    nil,
+			"at":      time.Date(2024, 5, 1, 15, 4, 5, 123, time.FixedZone("", -3600)),
+			"list":    []interface{}{1, "a", map[string]interface{}{"k": []string{"x", "&"}}},
+		},
+	} {
+		b, _ := json.Marshal(v)
+		if n, ok := sizeBound(v); !ok || n < len(b) {
+			t.Errorf("sizeBound(%s) = %d, %v", b, n, ok)
+		}
+	}
+	if _, ok := sizeBound(map[string]interface{}{"s": struct{}{}}); ok {
+		t.Error("bound of struct must be unknown")
+	}
+}
@@ -0,0 +1,52 @@
+package gcplog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// ErrInvalidPayload is returned by LogSyncResult when entry is
+// dropped as its payload is rejected by WithPayloadValidator function.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// validator checks structured payloads before they are emitted.
+type validator struct {
+	f    func(map[string]interface{}) error
+	drop bool
+}
+
+// warner returns logger of warnings about entries of s. Its entries
+// are clean of fields, IDs and operation bound to s, and aren't
+// validated to not recurse. Error key set with WithErrorKey applies.
+func (s *Stackdriver) warner() *Stackdriver {
+	c := s.clone()
+	c.fields = nil
+	c.group = nil
+	c.err = nil
+	c.insertID = ""
+	c.insertSeq = nil
+	c.timestamp = time.Time{}
+	c.op = nil
+	c.opLast = false
+	c.validate = nil
+	return c
+}
+
+// validatePayload checks payload of entry with severity sev and logs
+// warning when it's invalid. Error is returned when the entry must
+// be dropped.
+func (s *Stackdriver) validatePayload(sev Severity, payload map[string]interface{}) error {
+	err := s.validate.f(payload)
+	if err == nil {
+		return nil
+	}
+	s.warner().logKV(logging.Warning, "payload schema violation",
+		errorKey, err.Error(), "entry_severity", sev.String(), "entry_message", payload["message"], "dropped", s.validate.drop)
+	if s.validate.drop {
+		return fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func requireUser(p map[string]interface{}) error {
+	if _, ok := p["user"].(string); !ok {
+		return fmt.Errorf("user must be string, got %v", p["user"])
+	}
+	return nil
+}
+
+func TestWithPayloadValidator(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := []gcplog.Option{
+			gcplog.WithStructuredStdout(),
+			gcplog.WithWriter(&buf),
+			gcplog.WithGCP(false),
+			gcplog.WithPayloadValidator(requireUser),
+		}
+		if drop {
+			opts = append(opts, gcplog.WithDropInvalidPayloads())
+		}
+		l := gcplog.New(nil, opts...)
+		l.Info("ok", "user", "bob")
+		l.Info("bad", "user", 42)
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		want := 3
+		if drop {
+			want = 2
+		}
+		if len(lines) != want {
+			t.Fatalf("drop %v: unexpected output %q", drop, buf.String())
+		}
+		got := structuredLine(t, lines[1])
+		if got["message"] != "payload schema violation" || got["severity"] != "WARNING" || got["entry_message"] != "bad" || got["dropped"] != drop {
+			t.Errorf("drop %v: unexpected warning %v", drop, got)
+		}
+	}
+}
+
+func TestPayloadValidatorWarningIsClean(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil,
+		gcplog.WithStructuredStdout(),
+		gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithPayloadValidator(requireUser),
+		gcplog.WithDropInvalidPayloads(),
+		gcplog.WithErrorKey("err"),
+	).WithFields(map[string]interface{}{"user": 42}).(*gcplog.Stackdriver)
+	l.WithInsertID("id").(*gcplog.Stackdriver).WithOperation("op", "test").Info("bad")
+
+	got := structuredLine(t, bytes.TrimSpace(buf.Bytes()))
+	if got["message"] != "payload schema violation" || got["err"] == nil {
+		t.Errorf("unexpected warning %v", got)
+	}
+	for _, k := range []string{"user", "error", "logging.googleapis.com/insertId", "logging.googleapis.com/operation"} {
+		if v, ok := got[k]; ok {
+			t.Errorf("warning has %s %v: %v", k, v, got)
+		}
+	}
+}
+
+func TestLogSyncResultInvalidPayload(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil,
+		gcplog.WithStructuredStdout(),
+		gcplog.WithWriter(&buf),
+		gcplog.WithGCP(false),
+		gcplog.WithPayloadValidator(requireUser),
+		gcplog.WithDropInvalidPayloads(),
+	)
+	if err := l.LogSyncResult(context.Background(), logging.Info, "bad"); !errors.Is(err, gcplog.ErrInvalidPayload) {
+		t.Errorf("expected ErrInvalidPayload, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package gcplog
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// maxWriterLine is size of partial line written by Writer
+// after which it's logged without waiting for newline.
+const maxWriterLine = 64 << 10
+
+// severityWriter is io.Writer logging written text with s.
+type severityWriter struct {
+	s   *Stackdriver
+	sev Severity
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Writer returns io.Writer logging written text as entries with
+// severity sev, e.g. for libraries accepting only io.Writer.
+//
+// Text is logged when newline is written, so a message written with
+// several calls is a single entry, and so is a multi-line message
+// written with one call. Trailing newline is trimmed. Text without
+// newline is kept until the next write.
+func (s *Stackdriver) Writer(sev Severity) io.Writer {
+	return &severityWriter{s: s, sev: sev}
+}
+
+// StdLogger returns *log.Logger logging with severity sev through
+// Writer, for code requiring concrete *log.Logger. It has no prefix
+// and flags, since entries are timestamped anyway:
+//
+//	srv.ErrorLog = l.StdLogger(logging.Error)
+func (s *Stackdriver) StdLogger(sev Severity) *log.Logger {
+	return log.New(s.Writer(sev), "", 0)
+}
+
+func (w *severityWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	i := bytes.LastIndexByte(w.buf, '\n')
+	if i < 0 && len(w.buf) < maxWriterLine {
+		return len(p), nil
+	}
+	if i < 0 {
+		i = len(w.buf)
+	}
+	w.log(string(bytes.TrimRight(w.buf[:i], "\r\n")))
+	if i < len(w.buf) {
+		i++
+	}
+	w.buf = append(w.buf[:0], w.buf[i:]...)
+	return len(p), nil
+}
+
+func (w *severityWriter) log(text string) {
+	if text == "" || !w.s.allow(w.sev, text) {
+		return
+	}
+	w.s.emit(logging.Entry{Severity: w.sev, Payload: text})
+}
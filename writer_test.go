@@ -0,0 +1,47 @@
+package gcplog_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+
+	"github.com/velppa/gcplog"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	w := l.Writer(logging.Error)
+
+	log.New(w, "", 0).Printf("100%% failed")
+	fmt.Fprint(w, "partial ")
+	if buf.Len() == 0 || strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("expected single entry before newline, got %q", buf.String())
+	}
+	fmt.Fprint(w, "line\nfirst\nsecond\n")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries, got %q", buf.String())
+	}
+	for i, want := range []string{"100% failed", "partial line\nfirst\nsecond"} {
+		got := structuredLine(t, []byte(lines[i]))
+		if got["message"] != want || got["severity"] != "ERROR" {
+			t.Errorf("entry %d = %v, want message %q", i, got, want)
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := gcplog.New(nil, gcplog.WithStructuredStdout(), gcplog.WithWriter(&buf), gcplog.WithGCP(false))
+	l.StdLogger(logging.Warning).Println("http: TLS handshake error")
+	got := structuredLine(t, buf.Bytes())
+	if got["message"] != "http: TLS handshake error" || got["severity"] != "WARNING" {
+		t.Errorf("unexpected entry %v", got)
+	}
+}
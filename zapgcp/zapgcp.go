@@ -0,0 +1,93 @@
+// Package zapgcp adapts gcplog.Stackdriver logger to zap, so that
+// applications using zap write entries to GCP. It's a separate package
+// to not make every user of gcplog depend on zap.
+package zapgcp
+
+import (
+	"cloud.google.com/go/logging"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/velppa/gcplog"
+)
+
+// loggerLabel is the label holding zap logger name,
+// the same gcplog Named uses.
+const loggerLabel = "logger"
+
+// core is zapcore.Core writing entries to Stackdriver logger.
+type core struct {
+	sd     *gcplog.Stackdriver
+	fields []zapcore.Field
+}
+
+// NewCore returns zap core backed by sd. Fields of entries become
+// payload fields, logger name is sent as "logger" label. Entries
+// of DPanic, Panic and Fatal levels are flushed before zap panics
+// or exits.
+func NewCore(sd *gcplog.Stackdriver) zapcore.Core {
+	return &core{sd: sd}
+}
+
+// SeverityForLevel maps zap level to GCP severity.
+func SeverityForLevel(level zapcore.Level) gcplog.Severity {
+	switch level {
+	case zapcore.DebugLevel:
+		return logging.Debug
+	case zapcore.InfoLevel:
+		return logging.Info
+	case zapcore.WarnLevel:
+		return logging.Warning
+	case zapcore.ErrorLevel:
+		return logging.Error
+	case zapcore.DPanicLevel, zapcore.PanicLevel, zapcore.FatalLevel:
+		return logging.Critical
+	default:
+		return logging.Default
+	}
+}
+
+func (c *core) Enabled(level zapcore.Level) bool {
+	return c.sd.Enabled(SeverityForLevel(level))
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	f := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	f = append(f, c.fields...)
+	f = append(f, fields...)
+	return &core{sd: c.sd, fields: f}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	args := make([]interface{}, 0, 2*len(enc.Fields))
+	for k, v := range enc.Fields {
+		args = append(args, k, v)
+	}
+	sev := SeverityForLevel(ent.Level)
+	if ent.LoggerName != "" {
+		c.sd.LogWithLabels(sev, gcplog.Labels{loggerLabel: ent.LoggerName}, ent.Message, args...)
+	} else {
+		c.sd.Log(sev, ent.Message, args...)
+	}
+	if ent.Level > zapcore.ErrorLevel {
+		// zap panics or exits right after writing, like zapcore's
+		// own cores sync so the entry isn't lost
+		return c.sd.Flush()
+	}
+	return nil
+}
+
+func (c *core) Sync() error { return c.sd.Flush() }
@@ -0,0 +1,69 @@
+package zapgcp_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"go.uber.org/zap"
+
+	"github.com/velppa/gcplog"
+	"github.com/velppa/gcplog/zapgcp"
+)
+
+func TestCore(t *testing.T) {
+	var buf bytes.Buffer
+	sd := gcplog.New(nil, gcplog.WithWriter(&buf), gcplog.WithGCP(false), gcplog.WithStdFlags(0))
+	l := zap.New(zapgcp.NewCore(sd)).With(zap.String("service", "api"))
+
+	l.Debug("hidden")
+	l.Warn("hello", zap.Int("n", 1), zap.String("message", "field"))
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug entry must be filtered by min level: %q", out)
+	}
+	if !strings.Contains(out, `{"message":"hello","message_field":"field","n":1,"service":"api"}`) {
+		t.Errorf("unexpected output %q", out)
+	}
+	if err := l.Sync(); err != nil {
+		t.Errorf("sync: %v", err)
+	}
+}
+
+func TestCoreFlushesPanic(t *testing.T) {
+	srv, opts := gcplog.NewTestServer()
+	defer srv.Close()
+	sd, err := gcplog.NewWithError(nil,
+		gcplog.WithStdout(false),
+		gcplog.WithProjectID("test-project"),
+		gcplog.WithClientOptions(opts...),
+		gcplog.WithFlushSettings(gcplog.FlushSettings{DelayThreshold: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sd.Close()
+	l := zap.New(zapgcp.NewCore(sd)).Named("worker")
+
+	func() {
+		defer func() { recover() }()
+		l.Panic("boom")
+	}()
+
+	entries := srv.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("panic entry must be flushed before zap panics, got %v", entries)
+	}
+	if e := entries[0]; e.GetJsonPayload().GetFields()["message"].GetStringValue() != "boom" || e.Labels["logger"] != "worker" {
+		t.Errorf("unexpected entry %v", e)
+	}
+}
+
+func TestSeverityForLevel(t *testing.T) {
+	if got := zapgcp.SeverityForLevel(zap.FatalLevel); got != logging.Critical {
+		t.Errorf("fatal: got %s, want %s", got, logging.Critical)
+	}
+}